@@ -1,10 +1,6 @@
 package ffthumbs
 
 import (
-	"crypto/sha256"
-	"encoding/binary"
-	"encoding/hex"
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -24,203 +20,356 @@ func BuildHeadersStr(headers map[string]string) string {
 }
 
 // BuildComplexFilters builds ffmpeg -filter_complex arg based on provided outputs config,
-// on fail it returns ValidationError
+// on fail it returns ValidationError. See BuildFilterGraph.
 func BuildComplexFilters(outputs []*OutputConfig) (string, error) {
-	if err := validateOutputs(outputs); err != nil {
+	graph, err := BuildFilterGraph(outputs)
+	if err != nil {
 		return "", err
 	}
 
-	grpOutputs := groupOutputs(outputs)
+	return graph.String(), nil
+}
 
-	var builder strings.Builder
+// BuildFilterGraph builds ffmpeg filter graph based on provided outputs config,
+// on fail it returns ValidationError.
+// Outputs in count mode (OutputConfig.Count) or with interval rules (OutputConfig.IntervalRules)
+// must have resolved SnapshotInterval, see ResolveCountInterval and IntervalRules.Resolve.
+//
+// Outputs sharing the same snapshot interval share the frames selection stage,
+// outputs sharing both snapshot interval and scale settings also share the scale stage:
+//
+//	[0:v] -> split (per interval) -> select -> split (per scale) -> pre-filters -> scale -> split (per output) ->
+//	post-filters -> output chain
+//
+// Split stages are omitted when there is nothing to split.
+func BuildFilterGraph(outputs []*OutputConfig) (*FilterGraph, error) {
+	return buildFilterGraph(outputs, &graphOptions{})
+}
+
+// graphOptions are request-wide filter graph options
+type graphOptions struct {
+	// keyframesOnly is set when only keyframes are decoded (see Config.KeyframesOnly)
+	keyframesOnly bool
+	// extractedInputs is set when frames of each select group are extracted beforehand (see framesGroup),
+	// select group i reads its frames from input i and no select filter is applied
+	extractedInputs bool
+	// skipRanges are the ranges which frames are dropped before the frames selection
+	skipRanges []TimeRange
+	// keyframes are the probed keyframes timestamps relative to the window start, set in keyframes only mode
+	keyframes []time.Duration
+	// windowDuration is the processing window duration, 0 when it is unknown, set in keyframes only mode
+	windowDuration time.Duration
+}
 
-	var mainIdx int
-	for _, subgrp := range grpOutputs {
-		// Outputs with same scale settings can be optimized
-		if len(subgrp) == 1 {
-			var outputs []*OutputConfig
+func buildFilterGraph(outputs []*OutputConfig, opts *graphOptions) (*FilterGraph, error) {
+	if err := validateResolvedOutputs(outputs); err != nil {
+		return nil, err
+	}
 
-			for _, subgrpOutputs := range subgrp {
-				outputs = subgrpOutputs
-				break
-			}
+	for idx, output := range outputs {
+		output.idx = idx
+	}
 
-			builder.WriteString(buildSelectFramesArg(outputs[0]))
-			builder.WriteString(buildScaleArg(&outputs[0].Scale))
-			builder.WriteString(buildSplitArg(outputs))
+	selectGroups := planOutputs(outputs, opts)
 
-			mainIdx++
-			// More outputs are coming
-			if mainIdx < len(grpOutputs) {
-				builder.WriteString(";")
-			}
+	graph := &FilterGraph{}
 
-			continue
+	inputs := []string{"0:v"}
+
+	var inputFilters []*Filter
+	if len(opts.skipRanges) > 0 && !opts.extractedInputs {
+		inputFilters = append(inputFilters, buildSkipRangesFilter(opts.skipRanges))
+	}
+
+	if opts.extractedInputs {
+		inputs = make([]string, 0, len(selectGroups))
+		for i := range selectGroups {
+			inputs = append(inputs, strconv.Itoa(i)+":v")
+		}
+	} else if len(selectGroups) > 1 {
+		selectNames := make([]string, 0, len(selectGroups))
+		for i := range selectGroups {
+			selectNames = append(selectNames, buildSelectGroupName(i))
+		}
+
+		graph.AddChain([]string{"0:v"}, selectNames, append(inputFilters, buildSplitFilter(len(selectNames)))...)
+		inputs = selectNames
+		inputFilters = nil
+	}
+
+	for i, selectGroup := range selectGroups {
+		selectFilters := inputFilters
+		if !opts.extractedInputs {
+			selectFilters = append(selectFilters, buildSelectFramesFilter(&selectGroup.selection, opts))
 		}
 
-		// Handle outputs with different scale settings
-		for _, outputs := range subgrp {
-			for idx, output := range outputs {
-				builder.WriteString(buildSelectFramesArg(output))
-				builder.WriteString(buildScaleArg(&output.Scale))
-				builder.WriteString(buildSplitArg([]*OutputConfig{output}))
+		if len(selectGroup.scaleGroups) == 1 {
+			addScaleGroupChains(graph, inputs[i], selectFilters, selectGroup.scaleGroups[0])
+			continue
+		}
 
-				if idx+1 < len(outputs) {
-					builder.WriteString(";")
-				}
-			}
+		scaleNames := make([]string, 0, len(selectGroup.scaleGroups))
+		for j := range selectGroup.scaleGroups {
+			scaleNames = append(scaleNames, buildScaleGroupName(i, j))
 		}
 
-		mainIdx++
-		// More outputs are coming
-		if mainIdx < len(grpOutputs) {
-			builder.WriteString(";")
+		graph.AddChain([]string{inputs[i]}, scaleNames, append(selectFilters, buildSplitFilter(len(scaleNames)))...)
+
+		for j, scaleGroup := range selectGroup.scaleGroups {
+			addScaleGroupChains(graph, scaleNames[j], nil, scaleGroup)
 		}
 	}
 
-	return builder.String(), nil
+	return graph, nil
 }
 
-// groupOutputs groups outputs by snapshot interval and then scale settings
-func groupOutputs(outputs []*OutputConfig) map[time.Duration]map[string][]*OutputConfig {
-	res := map[time.Duration]map[string][]*OutputConfig{}
+type (
+	// frameSelection configures which frames are selected
+	frameSelection struct {
+		interval time.Duration
+		// limit is a max frames number, 0 - no limit
+		limit int
+		// scene is set for the scene-change selection mode
+		scene SceneConfig
+		// isScene enables the scene-change selection mode
+		isScene bool
+		// snapToKeyframes selects the keyframe nearest to each interval boundary (see selectNearestKeyframes)
+		snapToKeyframes bool
+		// aligned selects the first frame of each interval range (see OutputConfig.ExactAlignment)
+		aligned bool
+	}
+
+	// selectGroup is a group of outputs sharing the same frames selection
+	selectGroup struct {
+		selection   frameSelection
+		scaleGroups []*scaleGroup
+	}
+
+	// scaleGroup is a group of outputs sharing the same frames selection, pre-filters and scale settings
+	scaleGroup struct {
+		scale      ScaleConfig
+		preFilters []*Filter
+		outputs    []*OutputConfig
+	}
+)
+
+// planOutputs groups outputs by snapshot interval and then pre-filters and scale settings,
+// groups are ordered by the first output occurrence
+func planOutputs(outputs []*OutputConfig, opts *graphOptions) []*selectGroup {
+	var groups []*selectGroup
 
 	for _, output := range outputs {
-		var ok bool
-		var snapshotMap map[string][]*OutputConfig
+		selection := buildFrameSelection(output, opts)
+
+		var selGroup *selectGroup
+
+		for _, group := range groups {
+			if group.selection == selection {
+				selGroup = group
+				break
+			}
+		}
 
-		if snapshotMap, ok = res[output.SnapshotInterval]; !ok {
-			snapshotMap = map[string][]*OutputConfig{}
-			res[output.SnapshotInterval] = snapshotMap
+		if selGroup == nil {
+			selGroup = &selectGroup{selection: selection}
+			groups = append(groups, selGroup)
 		}
 
-		tmpBytes := make([]byte, 0, 24)
-		binary.LittleEndian.AppendUint64(tmpBytes, uint64(output.Scale.Behavior))
-		binary.LittleEndian.AppendUint64(tmpBytes, uint64(output.Scale.Width))
-		binary.LittleEndian.AppendUint64(tmpBytes, uint64(output.Scale.Height))
+		var scGroup *scaleGroup
 
-		hashSum := sha256.Sum256(tmpBytes)
-		hashStr := hex.EncodeToString(hashSum[:])
+		for _, group := range selGroup.scaleGroups {
+			if group.scale.Eq(&output.Scale) && IsSameFilters(group.preFilters, output.PreFilters) {
+				scGroup = group
+				break
+			}
+		}
 
-		if _, ok = snapshotMap[hashStr]; !ok {
-			snapshotMap[hashStr] = []*OutputConfig{output}
-		} else {
-			snapshotMap[hashStr] = append(snapshotMap[hashStr], output)
+		if scGroup == nil {
+			scGroup = &scaleGroup{scale: output.Scale, preFilters: output.PreFilters}
+			selGroup.scaleGroups = append(selGroup.scaleGroups, scGroup)
 		}
+
+		scGroup.outputs = append(scGroup.outputs, output)
 	}
 
-	return res
+	return groups
 }
 
-func buildSelectFramesArg(output *OutputConfig) string {
-	var builder strings.Builder
+func buildFrameSelection(output *OutputConfig, opts *graphOptions) frameSelection {
+	if output.Scene != nil {
+		scene := *output.Scene
+		scene.Threshold = scene.GetThreshold()
 
-	builder.WriteString(`[0:v]select=bitor(gte(t-prev_selected_t\,`)
-	builder.WriteString(fmt.Sprintf("%g", output.SnapshotInterval.Truncate(time.Microsecond).Seconds()))
-	builder.WriteString(`)\,isnan(prev_selected_t)),`)
+		return frameSelection{scene: scene, isScene: true}
+	}
 
-	return builder.String()
+	return frameSelection{
+		interval:        output.SnapshotInterval,
+		limit:           output.Count,
+		snapToKeyframes: opts.keyframesOnly,
+		aligned:         output.ExactAlignment,
+	}
 }
 
-func buildScaleArg(scale *ScaleConfig) string {
-	var builder strings.Builder
+// addScaleGroupChains adds chains which scale frames and pass them to each output of the group,
+// filters are prepended to the scale filters
+func addScaleGroupChains(graph *FilterGraph, in string, filters []*Filter, group *scaleGroup) {
+	for _, output := range group.outputs {
+		output.inName, output.outName = buildOutputInOutNames(output)
+	}
 
-	builder.WriteString(`scale=`)
-	builder.WriteString(strconv.Itoa(scale.Width))
-	builder.WriteString(`:`)
-	builder.WriteString(strconv.Itoa(scale.Height))
+	filters = append(filters, group.preFilters...)
+	filters = append(filters, buildScaleFilters(&group.scale)...)
 
-	switch scale.Behavior {
-	case ScaleBehaviorFillToKeepAspectRatio:
-		builder.WriteString(`:force_original_aspect_ratio=decrease,pad=`)
-		builder.WriteString(strconv.Itoa(scale.Width))
-		builder.WriteString(`:`)
-		builder.WriteString(strconv.Itoa(scale.Height))
-		builder.WriteString(`:-1:-1:color=black`)
-	case ScaleBehaviorCropToFit:
-		builder.WriteString(`:force_original_aspect_ratio=increase,crop=`)
-		builder.WriteString(strconv.Itoa(scale.Width))
-		builder.WriteString(`:`)
-		builder.WriteString(strconv.Itoa(scale.Height))
+	if len(group.outputs) == 1 {
+		output := group.outputs[0]
+		filters = append(filters, buildOutputChainFilters(output)...)
+
+		graph.AddChain([]string{in}, []string{output.outName}, filters...)
+
+		return
 	}
 
-	return builder.String()
+	outputNames := make([]string, 0, len(group.outputs))
+	for _, output := range group.outputs {
+		outputNames = append(outputNames, output.inName)
+	}
+
+	filters = append(filters, buildSplitFilter(len(outputNames)))
+
+	graph.AddChain([]string{in}, outputNames, filters...)
+
+	for _, output := range group.outputs {
+		graph.AddChain([]string{output.inName}, []string{output.outName}, buildOutputChainFilters(output)...)
+	}
 }
 
-func buildSplitArg(outputs []*OutputConfig) string {
-	var builder strings.Builder
+func buildSelectGroupName(selectIdx int) string {
+	return "sel-" + strconv.Itoa(selectIdx)
+}
 
-	for _, output := range outputs {
-		switch output.Type {
-		case OutputTypeThumbs:
-			output.outName = buildSplitArgThumbOutName(output)
-		case OutputTypeSprites:
-			in, out := buildSplitArgSpiteInOutNames(output)
-			output.inName = in
-			output.outName = out
-		}
+func buildScaleGroupName(selectIdx, scaleIdx int) string {
+	return buildSelectGroupName(selectIdx) + "-scale-" + strconv.Itoa(scaleIdx)
+}
+
+func buildSplitFilter(outputsNum int) *Filter {
+	return NewFilter("split", Arg(outputsNum))
+}
+
+// buildSelectFramesFilter builds select filter of the selection, keyframes of opts are used in keyframes only mode
+func buildSelectFramesFilter(selection *frameSelection, opts *graphOptions) *Filter {
+	if selection.isScene {
+		return NewFilter("select", Arg(buildSceneSelectExpr(&selection.scene)))
 	}
 
-	if len(outputs) == 1 {
-		output := outputs[0]
+	interval := formatFilterOptionValue(selection.interval)
 
-		switch output.Type {
-		case OutputTypeThumbs:
-			writeFilterOutputName(&builder, output.outName)
-		case OutputTypeSprites:
-			builder.WriteString(",")
-			builder.WriteString(buildSplitSpriteTileArg(output))
-			writeFilterOutputName(&builder, output.outName)
-		}
+	// Only keyframes are decoded, the keyframes nearest to the interval boundaries are known beforehand
+	if selection.snapToKeyframes {
+		return NewFilter("select", Arg(buildKeyframesSelectExpr(selectNearestKeyframes(opts.keyframes, selection, opts.windowDuration), 0)))
+	}
 
-		return builder.String()
+	if selection.aligned {
+		return NewFilter("select", Arg(buildAlignedSelectExpr(selection, 0)))
 	}
 
-	builder.WriteString(",split=")
-	builder.WriteString(strconv.Itoa(len(outputs)))
+	// Frames count is limited, so the frames positions must not drift:
+	// frame N is the first frame at or after N*interval
+	if selection.limit > 0 {
+		expr := "lt(selected_n," + strconv.Itoa(selection.limit) + ")*gte(t,selected_n*" + interval + ")"
 
-	var needExtendedProcessing []*OutputConfig
+		return NewFilter("select", Arg(expr))
+	}
 
-	for _, output := range outputs {
-		builder.WriteString("[")
-
-		switch output.Type {
-		case OutputTypeThumbs:
-			builder.WriteString(output.outName)
-		case OutputTypeSprites:
-			needExtendedProcessing = append(needExtendedProcessing, output)
-			builder.WriteString(output.inName)
+	expr := "bitor(gte(t-prev_selected_t," + interval + "),isnan(prev_selected_t))"
+
+	return NewFilter("select", Arg(expr))
+}
+
+// buildAlignedSelectExpr builds select expression which selects the first frame of each interval range,
+// unlike the drifting interval expression it doesn't depend on the frames of the previous ranges.
+// offset is added to the frames timestamps.
+func buildAlignedSelectExpr(selection *frameSelection, offset time.Duration) string {
+	interval := formatFilterOptionValue(selection.interval)
+	rangeExpr := func(t string) string {
+		if offset != 0 {
+			t += "+" + formatFilterOptionValue(offset)
 		}
 
-		builder.WriteString("]")
+		return "floor((" + t + ")/" + interval + ")"
+	}
+
+	expr := "bitor(isnan(prev_selected_t),gt(" + rangeExpr("t") + "," + rangeExpr("prev_selected_t") + "))"
+
+	if selection.limit > 0 {
+		expr = "lt(" + rangeExpr("t") + "," + strconv.Itoa(selection.limit) + ")*" + expr
+	}
+
+	return expr
+}
+
+// buildSkipRangesFilter builds filter which drops frames within the ranges
+func buildSkipRangesFilter(ranges []TimeRange) *Filter {
+	exprs := make([]string, 0, len(ranges))
+
+	for _, r := range ranges {
+		exprs = append(exprs, "bitor(lt(t,"+formatFilterOptionValue(r.Start)+"),gte(t,"+formatFilterOptionValue(r.End)+"))")
+	}
+
+	return NewFilter("select", Arg(strings.Join(exprs, "*"))).WithInstance("skip")
+}
+
+// buildSceneSelectExpr builds select expression which selects the first frame,
+// frames on scene cuts (not closer than MinGap to the previous one) and frames after MaxGap without scene cuts
+func buildSceneSelectExpr(scene *SceneConfig) string {
+	expr := "gt(scene," + formatFilterOptionValue(scene.Threshold) + ")"
+
+	if scene.MinGap > 0 {
+		expr += "*gte(t-prev_selected_t," + formatFilterOptionValue(scene.MinGap) + ")"
 	}
 
-	if len(needExtendedProcessing) == 0 {
-		return builder.String()
+	expr = "bitor(isnan(prev_selected_t)," + expr + ")"
+
+	if scene.MaxGap > 0 {
+		expr = "bitor(" + expr + ",gte(t-prev_selected_t," + formatFilterOptionValue(scene.MaxGap) + "))"
 	}
 
-	builder.WriteString(";")
+	return expr
+}
+
+func buildScaleFilters(scale *ScaleConfig) []*Filter {
+	scaleFilter := NewFilter("scale", Arg(scale.Width), Arg(scale.Height))
+
+	switch scale.Behavior {
+	case ScaleBehaviorFillToKeepAspectRatio:
+		scaleFilter.Options = append(scaleFilter.Options, Opt("force_original_aspect_ratio", "decrease"))
 
-	var idx = 0
-	for _, output := range needExtendedProcessing {
-		switch output.Type {
-		case OutputTypeSprites:
-			builder.WriteString(buildSplitSpriteArg(output))
+		return []*Filter{
+			scaleFilter,
+			NewFilter("pad", Arg(scale.Width), Arg(scale.Height), Arg(-1), Arg(-1), Opt("color", "black")),
 		}
+	case ScaleBehaviorCropToFit:
+		scaleFilter.Options = append(scaleFilter.Options, Opt("force_original_aspect_ratio", "increase"))
 
-		idx++
-		if idx < len(outputs) {
-			builder.WriteString(";")
+		return []*Filter{
+			scaleFilter,
+			NewFilter("crop", Arg(scale.Width), Arg(scale.Height)),
 		}
 	}
 
-	return builder.String()
+	return []*Filter{scaleFilter}
 }
 
-func buildSplitArgSpiteInOutNames(output *OutputConfig) (in, out string) {
+func buildOutputInOutNames(output *OutputConfig) (in, out string) {
 	var nameBuilder strings.Builder
 
-	nameBuilder.WriteString("sprites-")
+	switch output.Type {
+	case OutputTypeThumbs:
+		nameBuilder.WriteString("thumbs-")
+	case OutputTypeSprites:
+		nameBuilder.WriteString("sprites-")
+	}
+
 	nameBuilder.WriteString(strconv.Itoa(output.idx))
 
 	in = nameBuilder.String()
@@ -232,41 +381,98 @@ func buildSplitArgSpiteInOutNames(output *OutputConfig) (in, out string) {
 	return
 }
 
-func buildSplitArgThumbOutName(output *OutputConfig) string {
-	var nameBuilder strings.Builder
+// buildOutputChainFilters builds per-output filters chain,
+// showinfo filter reports PTS of each emitted frame (see parseShowInfoLine)
+func buildOutputChainFilters(output *OutputConfig) []*Filter {
+	filters := make([]*Filter, 0, len(output.PostFilters)+2)
+	filters = append(filters, output.PostFilters...)
+	filters = append(filters, buildShowInfoFilter(output))
 
-	nameBuilder.WriteString("thumbs-")
-	nameBuilder.WriteString(strconv.Itoa(output.idx))
-	nameBuilder.WriteString("-out")
+	if output.Scene != nil {
+		filters = append(filters, buildSceneScoreFilter(output))
+	}
 
-	return nameBuilder.String()
-}
+	if output.Type == OutputTypeSprites {
+		filters = append(filters, buildSpriteTileFilter(output))
+	}
 
-func buildSplitSpriteArg(output *OutputConfig) string {
-	var builder strings.Builder
+	return filters
+}
 
-	writeFilterOutputName(&builder, output.inName)
+func buildShowInfoFilter(output *OutputConfig) *Filter {
+	return NewFilter("showinfo").WithInstance("frames-" + strconv.Itoa(output.idx))
+}
 
-	builder.WriteString(buildSplitSpriteTileArg(output))
+// buildSceneScoreFilter builds filter which reports scene score of each emitted frame (see parseSceneScoreLine)
+func buildSceneScoreFilter(output *OutputConfig) *Filter {
+	return NewFilter("metadata", Opt("mode", "print"), Opt("key", "lavfi.scene_score")).
+		WithInstance("scene-" + strconv.Itoa(output.idx))
+}
 
-	writeFilterOutputName(&builder, output.outName)
+func buildSpriteTileFilter(output *OutputConfig) *Filter {
+	layout := strconv.Itoa(output.Sprites.Dimensions.Columns) + "x" + strconv.Itoa(output.Sprites.Dimensions.Rows)
 
-	return builder.String()
+	return NewFilter("tile", Arg(layout))
 }
 
-func buildSplitSpriteTileArg(output *OutputConfig) string {
-	var builder strings.Builder
+// keyframeTolerance is a max difference between the probed and the decoded keyframe timestamps,
+// ffprobe reports timestamps rounded to microseconds
+const keyframeTolerance = time.Millisecond
 
-	builder.WriteString("tile=")
-	builder.WriteString(strconv.Itoa(output.Sprites.Dimensions.Columns))
-	builder.WriteString(`x`)
-	builder.WriteString(strconv.Itoa(output.Sprites.Dimensions.Rows))
+// selectNearestKeyframes returns the keyframes nearest to the selection interval boundaries within duration
+// (0 - no limit), keyframes must be sorted, the earlier keyframe is picked when two keyframes are equally near.
+// A keyframe nearest to several boundaries is returned once, so fewer frames than boundaries could be selected.
+func selectNearestKeyframes(keyframes []time.Duration, selection *frameSelection, duration time.Duration) []time.Duration {
+	if len(keyframes) == 0 || selection.interval <= 0 {
+		return nil
+	}
 
-	return builder.String()
+	distance := func(keyframe, boundary time.Duration) time.Duration {
+		if keyframe < boundary {
+			return boundary - keyframe
+		}
+
+		return keyframe - boundary
+	}
+
+	var selected []time.Duration
+
+	for n, i := 0, 0; selection.limit <= 0 || n < selection.limit; n++ {
+		boundary := time.Duration(n) * selection.interval
+		if n > 0 && duration > 0 && boundary >= duration {
+			break
+		}
+
+		for i+1 < len(keyframes) && distance(keyframes[i+1], boundary) < distance(keyframes[i], boundary) {
+			i++
+		}
+
+		if len(selected) == 0 || selected[len(selected)-1] != keyframes[i] {
+			selected = append(selected, keyframes[i])
+		}
+
+		// The following boundaries are nearest to the last keyframe as well
+		if boundary >= keyframes[len(keyframes)-1] {
+			break
+		}
+	}
+
+	return selected
 }
 
-func writeFilterOutputName(builder *strings.Builder, name string) {
-	builder.WriteString("[")
-	builder.WriteString(name)
-	builder.WriteString("]")
+// buildKeyframesSelectExpr builds select expression which selects frames of the keyframes timestamps,
+// offset is added to the frames timestamps
+func buildKeyframesSelectExpr(keyframes []time.Duration, offset time.Duration) string {
+	if len(keyframes) == 0 {
+		return "0"
+	}
+
+	tolerance := formatFilterOptionValue(keyframeTolerance)
+	terms := make([]string, 0, len(keyframes))
+
+	for _, keyframe := range keyframes {
+		terms = append(terms, "lt(abs(t-"+formatFilterOptionValue(keyframe-offset)+"),"+tolerance+")")
+	}
+
+	return strings.Join(terms, "+")
 }
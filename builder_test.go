@@ -0,0 +1,307 @@
+package ffthumbs
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBuildComplexFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs []*OutputConfig
+		want    string
+	}{
+		{
+			name: "single thumbs",
+			outputs: []*OutputConfig{
+				{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+			},
+			want: `[0:v]select=bitor(gte(t-prev_selected_t\,5)\,isnan(prev_selected_t)),scale=320:180,showinfo@frames-0[thumbs-0-out]`,
+		},
+		{
+			name: "single sprites",
+			outputs: []*OutputConfig{
+				{
+					Type:             OutputTypeSprites,
+					SnapshotInterval: 10 * time.Second,
+					Scale:            ScaleConfig{Width: 160, Height: 90, Behavior: ScaleBehaviorCropToFit},
+					Sprites:          SpritesConfig{Dimensions: SpriteDimensions{Columns: 5, Rows: 5}},
+				},
+			},
+			want: `[0:v]select=bitor(gte(t-prev_selected_t\,10)\,isnan(prev_selected_t)),scale=160:90:force_original_aspect_ratio=increase,crop=160:90,showinfo@frames-0,tile=5x5[sprites-0-out]`,
+		},
+		{
+			name: "same interval and scale share scale stage",
+			outputs: []*OutputConfig{
+				{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+				{
+					Type:             OutputTypeSprites,
+					SnapshotInterval: 5 * time.Second,
+					Scale:            ScaleConfig{Width: 320, Height: 180},
+					Sprites:          SpritesConfig{Dimensions: SpriteDimensions{Columns: 10, Rows: 10}},
+				},
+			},
+			want: `[0:v]select=bitor(gte(t-prev_selected_t\,5)\,isnan(prev_selected_t)),scale=320:180,split=2[thumbs-0][sprites-1];` +
+				`[thumbs-0]showinfo@frames-0[thumbs-0-out];` +
+				`[sprites-1]showinfo@frames-1,tile=10x10[sprites-1-out]`,
+		},
+		{
+			name: "same interval different scales get separate branches",
+			outputs: []*OutputConfig{
+				{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+				{
+					Type:             OutputTypeThumbs,
+					SnapshotInterval: 5 * time.Second,
+					Scale:            ScaleConfig{Width: 640, Height: 360, Behavior: ScaleBehaviorFillToKeepAspectRatio},
+				},
+			},
+			want: `[0:v]select=bitor(gte(t-prev_selected_t\,5)\,isnan(prev_selected_t)),split=2[sel-0-scale-0][sel-0-scale-1];` +
+				`[sel-0-scale-0]scale=320:180,showinfo@frames-0[thumbs-0-out];` +
+				`[sel-0-scale-1]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:-1:-1:color=black,showinfo@frames-1[thumbs-1-out]`,
+		},
+		{
+			name: "different intervals get separate selection",
+			outputs: []*OutputConfig{
+				{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+				{Type: OutputTypeThumbs, SnapshotInterval: 2500 * time.Millisecond, Scale: ScaleConfig{Width: 320, Height: 180}},
+			},
+			want: `[0:v]split=2[sel-0][sel-1];` +
+				`[sel-0]select=bitor(gte(t-prev_selected_t\,5)\,isnan(prev_selected_t)),scale=320:180,showinfo@frames-0[thumbs-0-out];` +
+				`[sel-1]select=bitor(gte(t-prev_selected_t\,2.5)\,isnan(prev_selected_t)),scale=320:180,showinfo@frames-1[thumbs-1-out]`,
+		},
+		{
+			name: "mixed",
+			outputs: []*OutputConfig{
+				{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+				{Type: OutputTypeThumbs, SnapshotInterval: 10 * time.Second, Scale: ScaleConfig{Width: -1, Height: 360}},
+				{
+					Type:             OutputTypeSprites,
+					SnapshotInterval: 5 * time.Second,
+					Scale:            ScaleConfig{Width: 320, Height: 180},
+					Sprites:          SpritesConfig{Dimensions: SpriteDimensions{Columns: 4, Rows: 4}},
+				},
+				{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second, Scale: ScaleConfig{Width: 640, Height: 360}},
+			},
+			want: `[0:v]split=2[sel-0][sel-1];` +
+				`[sel-0]select=bitor(gte(t-prev_selected_t\,5)\,isnan(prev_selected_t)),split=2[sel-0-scale-0][sel-0-scale-1];` +
+				`[sel-0-scale-0]scale=320:180,split=2[thumbs-0][sprites-2];` +
+				`[thumbs-0]showinfo@frames-0[thumbs-0-out];` +
+				`[sprites-2]showinfo@frames-2,tile=4x4[sprites-2-out];` +
+				`[sel-0-scale-1]scale=640:360,showinfo@frames-3[thumbs-3-out];` +
+				`[sel-1]select=bitor(gte(t-prev_selected_t\,10)\,isnan(prev_selected_t)),scale=-1:360,showinfo@frames-1[thumbs-1-out]`,
+		},
+		{
+			name: "count mode",
+			outputs: []*OutputConfig{
+				{Type: OutputTypeThumbs, Count: 10, SnapshotInterval: 6 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+				{Type: OutputTypeThumbs, SnapshotInterval: 6 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+			},
+			want: `[0:v]split=2[sel-0][sel-1];` +
+				`[sel-0]select=lt(selected_n\,10)*gte(t\,selected_n*6),scale=320:180,showinfo@frames-0[thumbs-0-out];` +
+				`[sel-1]select=bitor(gte(t-prev_selected_t\,6)\,isnan(prev_selected_t)),scale=320:180,showinfo@frames-1[thumbs-1-out]`,
+		},
+		{
+			name: "scene mode",
+			outputs: []*OutputConfig{
+				{
+					Type:  OutputTypeThumbs,
+					Scene: &SceneConfig{Threshold: 0.3, MinGap: 2 * time.Second, MaxGap: 30 * time.Second},
+					Scale: ScaleConfig{Width: 320, Height: 180},
+				},
+			},
+			want: `[0:v]select=bitor(bitor(isnan(prev_selected_t)\,gt(scene\,0.3)*gte(t-prev_selected_t\,2))\,gte(t-prev_selected_t\,30)),` +
+				`scale=320:180,showinfo@frames-0,metadata@scene-0=mode=print:key=lavfi.scene_score[thumbs-0-out]`,
+		},
+		{
+			name: "custom pre and post filters",
+			outputs: []*OutputConfig{
+				{
+					Type:             OutputTypeThumbs,
+					SnapshotInterval: 5 * time.Second,
+					Scale:            ScaleConfig{Width: 320, Height: 180},
+					PostFilters:      []*Filter{NewFilter("unsharp")},
+				},
+				{
+					Type:             OutputTypeThumbs,
+					SnapshotInterval: 5 * time.Second,
+					Scale:            ScaleConfig{Width: 320, Height: 180},
+					PreFilters:       []*Filter{NewFilter("eq", Opt("brightness", 0.1))},
+				},
+				{
+					Type:             OutputTypeSprites,
+					SnapshotInterval: 5 * time.Second,
+					Scale:            ScaleConfig{Width: 320, Height: 180},
+					Sprites:          SpritesConfig{Dimensions: SpriteDimensions{Columns: 2, Rows: 2}},
+				},
+			},
+			want: `[0:v]select=bitor(gte(t-prev_selected_t\,5)\,isnan(prev_selected_t)),split=2[sel-0-scale-0][sel-0-scale-1];` +
+				`[sel-0-scale-0]scale=320:180,split=2[thumbs-0][sprites-2];` +
+				`[thumbs-0]unsharp,showinfo@frames-0[thumbs-0-out];` +
+				`[sprites-2]showinfo@frames-2,tile=2x2[sprites-2-out];` +
+				`[sel-0-scale-1]eq=brightness=0.1,scale=320:180,showinfo@frames-1[thumbs-1-out]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildComplexFilters(tt.outputs)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("filters mismatch\ngot:  %s\nwant: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFilterGraphKeyframesOnly(t *testing.T) {
+	outputs := []*OutputConfig{
+		{Type: OutputTypeThumbs, SnapshotInterval: 10 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}, KeyframeSnapping: true},
+	}
+
+	opts := &graphOptions{
+		keyframesOnly:  true,
+		keyframes:      []time.Duration{0, 9500 * time.Millisecond, 12 * time.Second, 21 * time.Second},
+		windowDuration: 25 * time.Second,
+	}
+
+	graph, err := buildFilterGraph(outputs, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Keyframe at 12s is nearer to 10s than to 20s, but 9.5s is the nearest one
+	want := `[0:v]select=lt(abs(t-0)\,0.001)+lt(abs(t-9.5)\,0.001)+lt(abs(t-21)\,0.001),` +
+		`scale=320:180,showinfo@frames-0[thumbs-0-out]`
+
+	if got := graph.String(); got != want {
+		t.Errorf("filters mismatch\ngot:  %s\nwant: %s", got, want)
+	}
+
+	outputs = append(outputs, &OutputConfig{Type: OutputTypeThumbs, SnapshotInterval: time.Second, Scale: ScaleConfig{Width: 320, Height: 180}})
+
+	err = validateConfig(&Config{KeyframesOnly: true, Outputs: outputs})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Type != ValidationErrTypeKeyframes {
+		t.Errorf("expected keyframes ValidationError, got: %v", err)
+	}
+}
+
+func TestBuildComplexFiltersValidation(t *testing.T) {
+	_, err := BuildComplexFilters(nil)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected ValidationError, got: %v", err)
+	}
+
+	if validationErr.Type != ValidationErrTypeNoOutputs {
+		t.Errorf("expected ValidationErrTypeNoOutputs, got: %d", validationErr.Type)
+	}
+
+	// Count mode output interval must be resolved before building filters
+	_, err = BuildComplexFilters([]*OutputConfig{
+		{Type: OutputTypeThumbs, Count: 10, Scale: ScaleConfig{Width: 320, Height: 180}},
+	})
+
+	if !errors.As(err, &validationErr) || validationErr.Type != ValidationErrTypeSnapshotInterval {
+		t.Errorf("expected snapshot interval ValidationError, got: %v", err)
+	}
+}
+
+func TestValidateCustomFilters(t *testing.T) {
+	outputs := []*OutputConfig{
+		{
+			Type:             OutputTypeThumbs,
+			SnapshotInterval: time.Second,
+			Scale:            ScaleConfig{Width: 320, Height: 180},
+			PostFilters:      []*Filter{NewFilter("hue", Opt("s", 0)), NewFilter("nosuchfilter")},
+		},
+	}
+
+	if err := validateOutputs(outputs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := validateOutputsFiltersAvailability(outputs, map[string]struct{}{"hue": {}})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Type != ValidationErrTypeFilter {
+		t.Fatalf("expected filter ValidationError, got: %v", err)
+	}
+
+	outputs[0].PostFilters = []*Filter{{}}
+
+	if err := validateOutputs(outputs); !errors.As(err, &validationErr) || validationErr.Type != ValidationErrTypeFilter {
+		t.Fatalf("expected filter ValidationError for unnamed filter, got: %v", err)
+	}
+}
+
+func TestIsSameScaleConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		c1   *ScaleConfig
+		c2   *ScaleConfig
+		want bool
+	}{
+		{name: "both nil", want: true},
+		{name: "one nil", c1: &ScaleConfig{Width: 320, Height: 180}, want: false},
+		{name: "equal", c1: &ScaleConfig{Width: 320, Height: 180}, c2: &ScaleConfig{Width: 320, Height: 180}, want: true},
+		{name: "different size", c1: &ScaleConfig{Width: 320, Height: 180}, c2: &ScaleConfig{Width: 640, Height: 180}, want: false},
+		{
+			name: "different behavior",
+			c1:   &ScaleConfig{Width: 320, Height: 180},
+			c2:   &ScaleConfig{Width: 320, Height: 180, Behavior: ScaleBehaviorCropToFit},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSameScaleConfig(tt.c1, tt.c2); got != tt.want {
+				t.Errorf("IsSameScaleConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSameOutputConfigFilters(t *testing.T) {
+	c1 := &OutputConfig{SnapshotInterval: time.Second, Scale: ScaleConfig{Width: 320, Height: 180}}
+	c2 := &OutputConfig{SnapshotInterval: time.Second, Scale: ScaleConfig{Width: 320, Height: 180}, Type: OutputTypeSprites}
+	c3 := &OutputConfig{SnapshotInterval: 2 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}}
+
+	if !IsSameOutputConfigFilters(c1, c2) {
+		t.Errorf("expected same filters for %+v and %+v", c1, c2)
+	}
+
+	if IsSameOutputConfigFilters(c1, c3) {
+		t.Errorf("expected different filters for %+v and %+v", c1, c3)
+	}
+
+	rules := &IntervalRules{Rules: []IntervalRule{{MaxDuration: time.Minute, Interval: time.Second}, {Interval: 10 * time.Second}}}
+
+	for _, c4 := range []*OutputConfig{
+		{SnapshotInterval: time.Second, Scale: c1.Scale, ExactAlignment: true},
+		{SnapshotInterval: time.Second, Scale: c1.Scale, KeyframeSnapping: true},
+		{SnapshotInterval: time.Second, Scale: c1.Scale, IntervalRules: rules},
+	} {
+		if IsSameOutputConfigFilters(c1, c4) {
+			t.Errorf("expected different filters for %+v and %+v", c1, c4)
+		}
+	}
+
+	c5 := &OutputConfig{SnapshotInterval: time.Second, Scale: c1.Scale, IntervalRules: &IntervalRules{Rules: slices.Clone(rules.Rules)}}
+	if !IsSameOutputConfigFilters(c5, &OutputConfig{SnapshotInterval: time.Second, Scale: c1.Scale, IntervalRules: rules}) {
+		t.Errorf("expected same filters for equal interval rules")
+	}
+
+	c5.IntervalRules.MaxFrames = 100
+	if IsSameOutputConfigFilters(c5, &OutputConfig{SnapshotInterval: time.Second, Scale: c1.Scale, IntervalRules: rules}) {
+		t.Errorf("expected different filters for different interval rules")
+	}
+}
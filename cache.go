@@ -0,0 +1,340 @@
+package ffthumbs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrCacheMiss is returned by ResultCache.Get when there is no entry
+var ErrCacheMiss = errors.New("cache miss")
+
+type (
+	// ResultCache stores results of the processed requests, so unchanged media isn't processed again (see Config.Cache).
+	// Keys are built from the input fingerprint (size and mtime of the local file, ETag or Last-Modified
+	// of the HTTP resource), the outputs configuration and the request outputs destinations.
+	ResultCache interface {
+		// Get returns the entry stored by key, ErrCacheMiss is returned when there is no entry
+		Get(ctx context.Context, key string) (*CacheEntry, error)
+		// Put stores the entry by key
+		Put(ctx context.Context, key string, entry *CacheEntry) error
+	}
+
+	// CacheEntry is a stored request result
+	CacheEntry struct {
+		// Outputs describes frames written to each output, ordered as Config.Outputs
+		Outputs []*OutputResult `json:"outputs"`
+		// Files are the written files, the entry is used only when all of them are intact (same size and mtime)
+		Files []CachedFile `json:"files"`
+		// CreatedAt is the entry creation time
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// CachedFile is a file written by the request
+	CachedFile struct {
+		Path    string    `json:"path"`
+		Size    int64     `json:"size"`
+		ModTime time.Time `json:"mod_time"`
+	}
+
+	// DirCache is ResultCache storing entries as JSON files in the local directory
+	DirCache struct {
+		// Dir is the entries directory, it is created on the first Put
+		Dir string
+	}
+)
+
+func (c DirCache) Get(_ context.Context, key string) (*CacheEntry, error) {
+	data, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrCacheMiss
+		}
+
+		return nil, fmt.Errorf("cannot read cache entry: %w", err)
+	}
+
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("cannot decode cache entry: %w", err)
+	}
+
+	return &entry, nil
+}
+
+func (c DirCache) Put(_ context.Context, key string, entry *CacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("cannot encode cache entry: %w", err)
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return fmt.Errorf("cannot create cache dir: %w", err)
+	}
+
+	// Concurrent readers never see a partially written entry
+	tmp, err := os.CreateTemp(c.Dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("cannot create cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write cache entry: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write cache entry: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), c.entryPath(key)); err != nil {
+		return fmt.Errorf("cannot write cache entry: %w", err)
+	}
+
+	return nil
+}
+
+func (c DirCache) entryPath(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// intact tells whether all the entry files exist and have the stored sizes and modification times
+func (e *CacheEntry) intact() bool {
+	for _, file := range e.Files {
+		info, err := os.Stat(file.Path)
+		if err != nil || info.Size() != file.Size || !info.ModTime().Equal(file.ModTime) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hashOutputsConfig returns a canonical hash of the outputs configuration and the config options
+// affecting the frames selection
+func hashOutputsConfig(cfg *Config) (string, error) {
+	data, err := json.Marshal(struct {
+		Outputs         []*OutputConfig
+		Strategy        GenerateStrategy
+		KeyframesOnly   bool
+		SeekMinInterval time.Duration
+		Segments        int
+	}{
+		Outputs:         cfg.Outputs,
+		Strategy:        cfg.Strategy,
+		KeyframesOnly:   cfg.KeyframesOnly,
+		SeekMinInterval: cfg.getSeekMinInterval(),
+		Segments:        cfg.Segments,
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot hash outputs config: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// inputFingerprint returns the input identity: size and mtime of the local file, ETag or Last-Modified
+// of the HTTP resource. Empty fingerprint is returned when the input identity is unknown (e.g. RTMP stream).
+func (g *Generator) inputFingerprint(ctx context.Context, mediaURL string) (string, error) {
+	u, err := url.Parse(mediaURL)
+
+	switch {
+	// Windows paths are parsed as URLs with a single letter scheme
+	case err != nil || len(u.Scheme) <= 1:
+		return localFingerprint(mediaURL)
+	case u.Scheme == "file":
+		return localFingerprint(u.Path)
+	case u.Scheme == "http" || u.Scheme == "https":
+		return g.httpFingerprint(ctx, mediaURL)
+	default:
+		return "", nil
+	}
+}
+
+func localFingerprint(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot stat input: %w", err)
+	}
+
+	return "file " + strconv.FormatInt(info.Size(), 10) + " " + strconv.FormatInt(info.ModTime().UnixNano(), 10), nil
+}
+
+// httpFingerprint requests the resource headers with Config.Headers using Config.CacheHTTPClient,
+// the request is limited by Config.CacheHTTPTimeout
+func (g *Generator) httpFingerprint(ctx context.Context, mediaURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.cfg.getCacheHTTPTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, mediaURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot build input HEAD request: %w", err)
+	}
+
+	for name, value := range g.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := g.cfg.CacheHTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot request input headers: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("cannot request input headers: unexpected status %s", resp.Status)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return "etag " + etag, nil
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		return "last-modified " + lastModified + " " + resp.Header.Get("Content-Length"), nil
+	}
+
+	return "", nil
+}
+
+// cacheKey returns the request cache key, empty key is returned when the request result cannot be cached
+func (g *Generator) cacheKey(ctx context.Context, req *GenerateRequest) (string, error) {
+	fingerprint, err := g.inputFingerprint(ctx, req.MediaURL)
+	if err != nil || fingerprint == "" {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", fingerprint, g.cfg.outputsHash)
+	g.writeRequestIdentity(hash, req)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// loadCachedResult fills the result with the cached outputs, the request cache key is returned on cache miss,
+// empty key is returned when the result shouldn't be stored
+func (g *Generator) loadCachedResult(ctx context.Context, req *GenerateRequest, res *GenerateResult, slogArgs []slog.Attr) string {
+	logCtx := context.Background()
+
+	key, err := g.cacheKey(ctx, req)
+	if err != nil {
+		args := slogArgs
+		args = append(args,
+			slog.String("err", err.Error()),
+		)
+
+		g.logger.LogAttrs(logCtx, slog.LevelWarn, "input fingerprinting failed, cache is skipped", args...)
+
+		return ""
+	}
+
+	if key == "" {
+		return ""
+	}
+
+	entry, err := g.cfg.Cache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			args := slogArgs
+			args = append(args,
+				slog.String("err", err.Error()),
+			)
+
+			g.logger.LogAttrs(logCtx, slog.LevelWarn, "cache lookup failed", args...)
+		}
+
+		return key
+	}
+
+	if !entry.intact() {
+		return key
+	}
+
+	res.Outputs = entry.Outputs
+	res.Cached = true
+
+	g.logger.LogAttrs(logCtx, slog.LevelDebug, "Outputs are taken from cache", slogArgs...)
+
+	return ""
+}
+
+// storeResult stores outputs of the processed request in the cache
+func (g *Generator) storeResult(ctx context.Context, key string, req *GenerateRequest, outputs []*OutputResult, slogArgs []slog.Attr) {
+	entry := &CacheEntry{
+		Outputs:   outputs,
+		CreatedAt: time.Now(),
+	}
+
+	paths := make(map[string]bool)
+
+	addFile := func(path string) error {
+		if paths[path] {
+			return nil
+		}
+
+		paths[path] = true
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		entry.Files = append(entry.Files, CachedFile{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	}
+
+	err := func() error {
+		for _, output := range outputs {
+			for _, frame := range output.Frames {
+				if err := addFile(frame.File); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, output := range g.cfg.Outputs {
+			outputDst := req.getOutputDst(output)
+
+			if output.VTT != nil {
+				if err := addFile(req.getVTTDst(output, outputDst)); err != nil {
+					return err
+				}
+			}
+
+			if output.Manifest != nil {
+				if err := addFile(req.getManifestDst(output, outputDst)); err != nil {
+					return err
+				}
+			}
+		}
+
+		return g.cfg.Cache.Put(ctx, key, entry)
+	}()
+
+	if err != nil {
+		args := slogArgs
+		args = append(args,
+			slog.String("err", err.Error()),
+		)
+
+		g.logger.LogAttrs(context.Background(), slog.LevelWarn, "cache store failed", args...)
+	}
+}
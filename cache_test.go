@@ -0,0 +1,193 @@
+package ffthumbs_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codercms/ffthumbs"
+	"github.com/codercms/ffthumbs/ffthumbstest"
+)
+
+// newCachedGenerator returns generator with DirCache which fake ffmpeg writes two thumbs and counts its runs,
+// cfg is extended with the cache and outputs
+func newCachedGenerator(t *testing.T, cfg *ffthumbs.Config) (gen *ffthumbs.Generator, outputDir string, runs func() int) {
+	t.Helper()
+
+	outputDir = t.TempDir()
+
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffprobe"), ffthumbstest.Script{
+		Stdout: `{"streams": [{"index": 0, "codec_type": "video"}], "format": {"duration": "5.000000"}}`,
+	})
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+		Stderr: []string{
+			ffthumbstest.ShowInfoLine(0, 0, 0),
+			ffthumbstest.ShowInfoLine(0, 1, 10*time.Second),
+		},
+		Run: func([]string) {
+			for _, name := range []string{"0001.jpg", "0002.jpg"} {
+				os.WriteFile(filepath.Join(outputDir, name), []byte("jpeg"), 0644)
+			}
+		},
+	})
+
+	cfg.DisableProgressLogs = true
+	if cfg.Cache == nil {
+		cfg.Cache = ffthumbs.DirCache{Dir: t.TempDir()}
+	}
+
+	cfg.Outputs = []*ffthumbs.OutputConfig{
+		{
+			DstPath:          filepath.Join(outputDir, "%04d.jpg"),
+			Scale:            ffthumbs.ScaleConfig{Width: 320, Height: 180},
+			SnapshotInterval: 10 * time.Second,
+			Type:             ffthumbs.OutputTypeThumbs,
+			Manifest:         &ffthumbs.ManifestConfig{},
+		},
+	}
+
+	gen = newTestGenerator(t, executor, cfg)
+
+	runs = func() int {
+		return len(slices.DeleteFunc(executor.Calls(), func(call ffthumbstest.Call) bool {
+			return !slices.Contains(call.Args, "-i")
+		}))
+	}
+
+	return gen, outputDir, runs
+}
+
+// generateCached runs the request and checks whether the result was taken from the cache
+func generateCached(t *testing.T, gen *ffthumbs.Generator, mediaURL string, wantCached bool) {
+	t.Helper()
+
+	res, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: mediaURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Cached != wantCached {
+		t.Errorf("cached is %t, want %t", res.Cached, wantCached)
+	}
+
+	if frames := res.Outputs[0].Frames; len(frames) != 2 || frames[1].PTS != 10*time.Second {
+		t.Errorf("unexpected frames %+v", frames)
+	}
+}
+
+func TestGenerateCacheLocal(t *testing.T) {
+	gen, outputDir, runs := newCachedGenerator(t, &ffthumbs.Config{})
+
+	input := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(input, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	generateCached(t, gen, input, false)
+	generateCached(t, gen, input, true)
+
+	if runs() != 1 {
+		t.Errorf("ffmpeg was launched %d times", runs())
+	}
+
+	// Modified input
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(input, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	generateCached(t, gen, input, false)
+	generateCached(t, gen, input, true)
+
+	// Broken outputs
+	if err := os.Remove(filepath.Join(outputDir, "manifest.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	generateCached(t, gen, input, false)
+
+	// Output rewritten with the same size
+	if err := os.WriteFile(filepath.Join(outputDir, "0001.jpg"), []byte("JPEG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(filepath.Join(outputDir, "0001.jpg"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	generateCached(t, gen, input, false)
+
+	if runs() != 4 {
+		t.Errorf("ffmpeg was launched %d times", runs())
+	}
+}
+
+func TestGenerateCacheHTTP(t *testing.T) {
+	gen, _, runs := newCachedGenerator(t, &ffthumbs.Config{})
+
+	etag := `"v1"`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/live.m3u8" {
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+	}))
+	defer server.Close()
+
+	generateCached(t, gen, server.URL+"/video.mp4", false)
+	generateCached(t, gen, server.URL+"/video.mp4", true)
+
+	etag = `"v2"`
+
+	generateCached(t, gen, server.URL+"/video.mp4", false)
+
+	// No identity headers
+	generateCached(t, gen, server.URL+"/live.m3u8", false)
+	generateCached(t, gen, server.URL+"/live.m3u8", false)
+
+	if runs() != 4 {
+		t.Errorf("ffmpeg was launched %d times", runs())
+	}
+}
+
+func TestGenerateCacheHTTPTimeout(t *testing.T) {
+	gen, _, runs := newCachedGenerator(t, &ffthumbs.Config{CacheHTTPTimeout: 50 * time.Millisecond})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The headers request never completes
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	// The input is processed without cache
+	generateCached(t, gen, server.URL+"/video.mp4", false)
+	generateCached(t, gen, server.URL+"/video.mp4", false)
+
+	if runs() != 2 {
+		t.Errorf("ffmpeg was launched %d times", runs())
+	}
+}
+
+func TestGenerateCacheConfigChange(t *testing.T) {
+	cache := ffthumbs.DirCache{Dir: t.TempDir()}
+
+	input := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(input, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gen, _, _ := newCachedGenerator(t, &ffthumbs.Config{Cache: cache})
+	generateCached(t, gen, input, false)
+	generateCached(t, gen, input, true)
+
+	// Segments change the frames selection, the media is too short to be split
+	gen, _, _ = newCachedGenerator(t, &ffthumbs.Config{Cache: cache, Segments: 2})
+	generateCached(t, gen, input, false)
+}
@@ -1,24 +1,40 @@
 package ffthumbs
 
 import (
+	"bufio"
 	"fmt"
 	"os/exec"
+	"regexp"
+	"strings"
 
 	"github.com/hashicorp/go-version"
 )
 
 var (
 	minVersionRequired = version.Must(version.NewVersion("5.0.0"))
+
+	// filterLinePattern matches filter line of "ffmpeg -filters" output, e.g.
+	// " TSC scale             V->V       Scale the input video size and/or convert the image format."
+	filterLinePattern = regexp.MustCompile(`^\s*[T.][S.][C.]\s+(\S+)\s+\S*->\S*`)
 )
 
 // GetFfmpegVersion returns ffmpeg version number, e.g. 6.0 or 5.3.1
 func GetFfmpegVersion(ffmpegPath string) (*version.Version, error) {
-	output, err := exec.Command(ffmpegPath, "-version").CombinedOutput()
+	return getFfmpegVersion(OSExecutor{}, ffmpegPath)
+}
+
+func getFfmpegVersion(executor Executor, ffmpegPath string) (*version.Version, error) {
+	output, err := launchCommand(launchParams{
+		executor:   executor,
+		path:       ffmpegPath,
+		args:       []string{"-version"},
+		needStdout: true,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("cannot check ffmpeg version: %w", err)
 	}
 
-	if match := versionPattern.FindStringSubmatch(string(output)); len(match) > 1 {
+	if match := versionPattern.FindStringSubmatch(output); len(match) > 1 {
 		ver, err := version.NewVersion(match[1])
 		if err != nil {
 			return nil, fmt.Errorf("wrong ffmpeg version reported: %s :%w", match[1], err)
@@ -32,7 +48,11 @@ func GetFfmpegVersion(ffmpegPath string) (*version.Version, error) {
 
 // VerifyFfmpegVersion verifies that the provided ffmpeg binary meets the minimal version requirement
 func VerifyFfmpegVersion(ffmpegPath string) error {
-	ver, err := GetFfmpegVersion(ffmpegPath)
+	return verifyFfmpegVersion(OSExecutor{}, ffmpegPath)
+}
+
+func verifyFfmpegVersion(executor Executor, ffmpegPath string) error {
+	ver, err := getFfmpegVersion(executor, ffmpegPath)
 	if err != nil {
 		return err
 	}
@@ -44,6 +64,38 @@ func VerifyFfmpegVersion(ffmpegPath string) error {
 	return nil
 }
 
+// GetFfmpegFilters returns a set of filter names supported by the provided ffmpeg binary
+func GetFfmpegFilters(ffmpegPath string) (map[string]struct{}, error) {
+	return getFfmpegFilters(OSExecutor{}, ffmpegPath)
+}
+
+func getFfmpegFilters(executor Executor, ffmpegPath string) (map[string]struct{}, error) {
+	output, err := launchCommand(launchParams{
+		executor:   executor,
+		path:       ffmpegPath,
+		args:       []string{"-hide_banner", "-filters"},
+		needStdout: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list ffmpeg filters: %w", err)
+	}
+
+	filters := map[string]struct{}{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if match := filterLinePattern.FindStringSubmatch(scanner.Text()); len(match) > 1 {
+			filters[match[1]] = struct{}{}
+		}
+	}
+
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("cannot find any filter in ffmpeg filters list")
+	}
+
+	return filters, nil
+}
+
 // FindFfmpeg finds path to ffmpeg in OS $PATH path variable
 func FindFfmpeg() (string, error) {
 	// Find full path to the "ffmpeg" executable
@@ -66,28 +118,28 @@ func FindProbe() (string, error) {
 	return ffmpegPath, nil
 }
 
-func getVerifiedFfmpegPath(ffmpegPath string) (string, error) {
+func getVerifiedFfmpegPath(executor Executor, ffmpegPath string) (string, error) {
 	if len(ffmpegPath) == 0 {
-		realPath, err := FindFfmpeg()
+		realPath, err := executor.LookPath("ffmpeg")
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("cannot find ffmpeg binary: %w", err)
 		}
 
 		ffmpegPath = realPath
 	}
 
-	if err := VerifyFfmpegVersion(ffmpegPath); err != nil {
+	if err := verifyFfmpegVersion(executor, ffmpegPath); err != nil {
 		return "", err
 	}
 
 	return ffmpegPath, nil
 }
 
-func getVerifiedFfprobePath(ffmpegPath string) (string, error) {
+func getVerifiedFfprobePath(executor Executor, ffmpegPath string) (string, error) {
 	if len(ffmpegPath) == 0 {
-		realPath, err := FindProbe()
+		realPath, err := executor.LookPath("ffprobe")
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("cannot find ffprobe binary: %w", err)
 		}
 
 		ffmpegPath = realPath
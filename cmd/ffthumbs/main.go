@@ -12,6 +12,7 @@ import (
 
 var (
 	snapshotInterval time.Duration
+	count            int
 	width            int
 	height           int
 	scaleBehavior    ffthumbs.ScaleBehavior
@@ -26,6 +27,7 @@ func init() {
 	flag.StringVar(&input, "i", "", "Set media path to generate thumbnails")
 
 	flag.DurationVar(&snapshotInterval, "interval", time.Second*7, "Set snapshot interval for thumbnails")
+	flag.IntVar(&count, "count", 0, "Set exact thumbnails count evenly spread over the media (overrides -interval)")
 
 	flag.IntVar(&width, "width", 320, "Set desired thumbnails width")
 	flag.IntVar(&height, "height", 180, "Set desired thumbnails height")
@@ -67,6 +69,7 @@ func main() {
 				Type:             outputType,
 				DstPath:          dst,
 				SnapshotInterval: snapshotInterval,
+				Count:            count,
 				Scale: ffthumbs.ScaleConfig{
 					Width:    width,
 					Height:   height,
@@ -92,7 +95,7 @@ func main() {
 
 	start := time.Now()
 
-	if err := thumbsGen.Generate(req); err != nil {
+	if _, err := thumbsGen.Generate(req); err != nil {
 		log.Fatal(err)
 	}
 
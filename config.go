@@ -2,6 +2,8 @@ package ffthumbs
 
 import (
 	"log/slog"
+	"net/http"
+	"slices"
 	"time"
 )
 
@@ -37,14 +39,71 @@ const (
 const (
 	// DefaultFilename is an output default filename
 	DefaultFilename = "%04d.jpg"
+	// DefaultVTTFilename is a WebVTT track default filename
+	DefaultVTTFilename = "thumbnails.vtt"
+	// DefaultManifestFilename is a JSON frames manifest default filename
+	DefaultManifestFilename = "manifest.json"
+	// DefaultSceneThreshold is a default scene change score threshold
+	DefaultSceneThreshold = 0.4
+	// DefaultSeekMinInterval is a default min snapshot interval when GenerateStrategyAuto picks seeking
+	DefaultSeekMinInterval = 30 * time.Second
+	// DefaultSeekConcurrency is a default number of parallel seeking ffmpeg processes per request
+	DefaultSeekConcurrency = 4
+	// DefaultCancelGracePeriod is a default time given to the interrupted ffmpeg to exit
+	DefaultCancelGracePeriod = 5 * time.Second
+	// DefaultPriorityAging is a default waiting time raising priority of the queued request by one
+	DefaultPriorityAging = 10 * time.Second
+	// DefaultCacheHTTPTimeout is a default time limit of the HTTP input headers request made for the cache key
+	DefaultCacheHTTPTimeout = 10 * time.Second
+)
+
+// GenerateStrategy configures how frames are extracted from the media
+type GenerateStrategy int
+
+const (
+	// GenerateStrategyAuto picks GenerateStrategySeek when media duration is known and every output
+	// snapshot interval is at least Config.SeekMinInterval, GenerateStrategyDecodeAll otherwise
+	GenerateStrategyAuto GenerateStrategy = iota
+	// GenerateStrategyDecodeAll decodes the whole media stream and selects frames with the select filter
+	GenerateStrategyDecodeAll
+	// GenerateStrategySeek extracts each frame with input seeking (-ss) and composes outputs from
+	// the extracted frames, it is much faster for long snapshot intervals, requires ffprobe
+	// and doesn't support scene-change selection mode
+	GenerateStrategySeek
 )
 
 type (
 	Config struct {
 		// FfmpegPath path to ffmpeg binary, default: search binary in OS $PATH variable
 		FfmpegPath string
+		// FfprobePath path to ffprobe binary, default: search binary in OS $PATH variable,
+		// ffprobe is only required when some output needs media info (e.g. OutputConfig.VTT)
+		FfprobePath string
 		// Concurrency limit amount of concurrent thumbnails generation, default: 2
 		Concurrency int
+		// QueueSize limits amount of async requests waiting for a free worker, when the queue is full
+		// GenerateAsync blocks until the request is admitted by priority and TryGenerateAsync returns ErrQueueFull,
+		// default: 0 (no queue)
+		QueueSize int
+		// PriorityAging is a waiting time raising priority of the queued request by one (see GenerateRequest.Priority),
+		// it prevents starvation of the low priority requests, default: DefaultPriorityAging, negative value - no aging
+		PriorityAging time.Duration
+		// DedupKey returns a key of the async request, with EnableDedup a request submitted while the request
+		// with the same key is queued or running is attached to its job and gets a copy of the job GenerateResult
+		// with its own Req. Priority, Deadline and LogArgs of the attached request are ignored, requests with their own
+		// Context or OnProgress are never deduplicated since they cannot be shared with the job.
+		// Default: Generator.DefaultDedupKey (media URL, processing window and resolved outputs destinations)
+		DedupKey DedupKeyFunc
+		// EnableDedup enables deduplication of the in-flight async requests (see DedupKey), default: disabled
+		EnableDedup bool
+		// Cache stores results of the processed requests, a request of the unchanged input with the same outputs
+		// is answered with the stored result when its files are intact, see DirCache. Default: nil (no cache)
+		Cache ResultCache
+		// CacheHTTPClient requests headers of the HTTP inputs for the cache keys, default: http.DefaultClient
+		CacheHTTPClient *http.Client
+		// CacheHTTPTimeout limits the HTTP input headers request, the request result isn't cached when it fails,
+		// default: DefaultCacheHTTPTimeout
+		CacheHTTPTimeout time.Duration
 		// Headers configures which headers should pass ffmpeg if requested file is a network url
 		Headers map[string]string
 		// Outputs configure outputs of snapshots (thumbs)
@@ -53,8 +112,51 @@ type (
 		Logger *slog.Logger
 		// DisableProgressLogs ffmpeg's progress logs
 		DisableProgressLogs bool
+		// OnProgress receives requests progress updates, can be overridden in GenerateRequest.OnProgress.
+		// Media is probed to report Progress.Percent and Progress.ETA when ffprobe is available
+		OnProgress ProgressFunc
+		// KeyframesOnly enables fast decoding mode: only keyframes are decoded (-skip_frame nokey)
+		// and each snapshot is taken from the keyframe nearest to the interval boundary,
+		// accuracy is traded for speed, actual timestamps are reported in GenerateResult.
+		// Keyframes are listed with ffprobe, so it is required.
+		// Every output must tolerate keyframe snapping (see OutputConfig.KeyframeSnapping)
+		KeyframesOnly bool
+		// Strategy configures how frames are extracted, default: GenerateStrategyAuto,
+		// can be overridden in GenerateRequest.Strategy
+		Strategy GenerateStrategy
+		// SeekMinInterval is a min snapshot interval when GenerateStrategyAuto picks seeking,
+		// default: DefaultSeekMinInterval
+		SeekMinInterval time.Duration
+		// SeekConcurrency limits amount of parallel seeking ffmpeg processes per request,
+		// default: DefaultSeekConcurrency
+		SeekConcurrency int
+		// TempDir is a directory for the intermediate frames of GenerateStrategySeek and segmented processing,
+		// default: os.TempDir()
+		TempDir string
+		// Segments splits media of each request into the provided number of time segments decoded
+		// by parallel ffmpeg processes, selected frames are merged into the same outputs as a sequential run would write.
+		// Segment boundaries are placed on the snapshot interval boundaries and frames are selected
+		// per interval range, so outputs match a sequential run with OutputConfig.ExactAlignment.
+		// Requests which aren't split (e.g. media too short for the interval) select frames as configured by the outputs.
+		// Only applied to GenerateStrategyDecodeAll, requires ffprobe, doesn't support scene-change selection mode.
+		// Default: 0 (disabled)
+		Segments int
+		// Executor launches ffmpeg and ffprobe processes, default: OSExecutor
+		Executor Executor
+		// CancelGracePeriod is a time given to ffmpeg to exit after GenerateRequest.Context cancellation interrupted it,
+		// then the whole process group is killed. Only applied to the default executor,
+		// default: DefaultCancelGracePeriod, negative value - kill immediately
+		CancelGracePeriod time.Duration
+		// KeepCancelledOutputs keeps outputs partially written by the cancelled requests, by default they are removed
+		KeepCancelledOutputs bool
+		// RetryPolicy configures retries of requests failed with transient errors, default: nil (no retries)
+		RetryPolicy *RetryPolicy
 
 		filtersStr string
+		// outputsHash is a canonical hash of the outputs configuration used in the cache keys
+		outputsHash string
+		// dynamicOutputs is set when outputs must be resolved per request (e.g. OutputConfig.Count is used)
+		dynamicOutputs bool
 	}
 
 	// ScaleConfig is an output files resolution config
@@ -79,9 +181,41 @@ type (
 		// Scale configure scaling behavior
 		Scale ScaleConfig
 
+		// PreFilters are custom ffmpeg filters applied to the selected frames before scaling,
+		// outputs with different PreFilters do not share the scale stage
+		PreFilters []*Filter
+
+		// PostFilters are custom ffmpeg filters applied to the scaled frames
+		// (before frames are tiled into sprites)
+		PostFilters []*Filter
+
 		// SnapshotInterval indicates how often to make screenshots from video
 		SnapshotInterval time.Duration
 
+		// Count enables target-count mode: SnapshotInterval is ignored and resolved per request
+		// from the probed media duration, so exactly Count thumbnails (or sprite tiles) are evenly spread
+		// over the media, requires ffprobe
+		Count int
+
+		// Scene enables scene-change selection mode: frames are emitted on scene cuts,
+		// SnapshotInterval is ignored
+		Scene *SceneConfig
+
+		// IntervalRules enables adaptive interval mode: SnapshotInterval is ignored and resolved per request
+		// from the probed media duration using provided rules, requires ffprobe
+		IntervalRules *IntervalRules
+
+		// ExactAlignment enables interval alignment mode: frame N is the first frame at or after start + N*SnapshotInterval,
+		// where start is the media timeline start (GenerateRequest.Start when set), so positions don't drift
+		// and a frame gap longer than the interval skips the position instead of shifting the following frames.
+		// Media with non-zero start_time is handled since ffmpeg shifts the timeline to start at zero
+		// and the frames selection doesn't depend on the first frame timestamp
+		ExactAlignment bool
+
+		// KeyframeSnapping tells that the output tolerates snapshots taken from the keyframe nearest
+		// to the exact interval boundary, required by Config.KeyframesOnly
+		KeyframeSnapping bool
+
 		// Type configures output type, e.g. sprites or thumbs
 		Type OutputType
 
@@ -91,6 +225,62 @@ type (
 		// Quality configures quality level (0 = default, valid values are 1-31, lower is better)
 		// See: https://ffmpeg.org/ffmpeg-codecs.html#Options-21 (q:v option)
 		Quality int
+
+		// VTT enables WebVTT thumbnails track generation, only supported when Type is set to OutputTypeSprites
+		VTT *VTTConfig
+
+		// Manifest enables JSON frames manifest generation, see OutputResult
+		Manifest *ManifestConfig
+	}
+
+	// SceneConfig is a scene-change frames selection configuration
+	SceneConfig struct {
+		// Threshold is a scene change score threshold (0-1, higher means bigger change), default: DefaultSceneThreshold
+		Threshold float64
+
+		// MinGap is a minimal time between emitted frames, so action scenes do not flood the output, 0 - no limit
+		MinGap time.Duration
+
+		// MaxGap is a maximal time between emitted frames, when there is no scene change during MaxGap
+		// a frame is emitted anyway, so static videos still get coverage, 0 - no limit
+		MaxGap time.Duration
+	}
+
+	// IntervalRules is a duration-based snapshot interval rules table, e.g.
+	// "<2 min: every 1s, <30 min: every 5s, otherwise every 10s, never more than 600 frames"
+	IntervalRules struct {
+		// Rules are checked in order, the first rule matching media duration is used,
+		// when no rule matches the last rule is used
+		Rules []IntervalRule
+
+		// MaxFrames limits snapshots number, the interval is increased to fit the limit, 0 - no limit
+		MaxFrames int
+	}
+
+	// IntervalRule configures snapshot interval for the media shorter than MaxDuration
+	IntervalRule struct {
+		// MaxDuration is an exclusive media duration limit, 0 matches any duration
+		MaxDuration time.Duration
+
+		// Interval is a snapshot interval
+		Interval time.Duration
+	}
+
+	// VTTConfig is a WebVTT thumbnails track configuration
+	VTTConfig struct {
+		// DstPath sets track output path, default: sprites output dir + DefaultVTTFilename
+		// can be overridden in GenerateRequest.VTTDst
+		DstPath string
+
+		// BaseURL is prepended to the sprite filename (or URLTemplate) in every cue, e.g. "https://cdn.example.com/media/1/",
+		// default: sprite path relative to the track file
+		// can be overridden in GenerateRequest.VTTBaseURL
+		BaseURL string
+
+		// URLTemplate formats the sprite reference following BaseURL in every cue, "{name}" is replaced
+		// with the sprite filename and "{index}" with the sprite number starting from 1,
+		// e.g. "sprite-{index}.jpg?v=2", default: "{name}"
+		URLTemplate string
 	}
 
 	// SpritesConfig is a sprites output configuration
@@ -107,6 +297,80 @@ type (
 	}
 )
 
+// getSeekMinInterval returns SeekMinInterval or default value
+func (c *Config) getSeekMinInterval() time.Duration {
+	if c.SeekMinInterval <= 0 {
+		return DefaultSeekMinInterval
+	}
+
+	return c.SeekMinInterval
+}
+
+// getCancelGracePeriod returns CancelGracePeriod or default value
+func (c *Config) getCancelGracePeriod() time.Duration {
+	return resolveCancelGracePeriod(c.CancelGracePeriod)
+}
+
+// getPriorityAging returns PriorityAging or default value, 0 is returned when aging is disabled
+func (c *Config) getPriorityAging() time.Duration {
+	switch {
+	case c.PriorityAging == 0:
+		return DefaultPriorityAging
+	case c.PriorityAging < 0:
+		return 0
+	default:
+		return c.PriorityAging
+	}
+}
+
+// getCacheHTTPTimeout returns CacheHTTPTimeout or default value
+func (c *Config) getCacheHTTPTimeout() time.Duration {
+	if c.CacheHTTPTimeout <= 0 {
+		return DefaultCacheHTTPTimeout
+	}
+
+	return c.CacheHTTPTimeout
+}
+
+// getSeekConcurrency returns SeekConcurrency or default value
+func (c *Config) getSeekConcurrency() int {
+	if c.SeekConcurrency <= 0 {
+		return DefaultSeekConcurrency
+	}
+
+	return c.SeekConcurrency
+}
+
+func (c *Config) graphOptions() *graphOptions {
+	return &graphOptions{
+		keyframesOnly: c.KeyframesOnly,
+	}
+}
+
+// Resolve returns snapshot interval for the media of provided duration
+func (r *IntervalRules) Resolve(duration time.Duration) time.Duration {
+	if len(r.Rules) == 0 {
+		return 0
+	}
+
+	interval := r.Rules[len(r.Rules)-1].Interval
+
+	for _, rule := range r.Rules {
+		if rule.MaxDuration == 0 || duration < rule.MaxDuration {
+			interval = rule.Interval
+			break
+		}
+	}
+
+	if r.MaxFrames > 0 && duration > time.Duration(r.MaxFrames)*interval {
+		// Round up to not exceed the limit after truncation
+		interval = (duration + time.Duration(r.MaxFrames) - 1) / time.Duration(r.MaxFrames)
+		interval = (interval + time.Microsecond - 1).Truncate(time.Microsecond)
+	}
+
+	return interval
+}
+
 // Eq is ScaleConfig equal to another scale config
 func (c *ScaleConfig) Eq(cfg *ScaleConfig) bool {
 	return IsSameScaleConfig(c, cfg)
@@ -118,7 +382,7 @@ func IsSameScaleConfig(c1, c2 *ScaleConfig) bool {
 		return true
 	}
 
-	if c1 != nil || c2 != nil {
+	if c1 == nil || c2 == nil {
 		return false
 	}
 
@@ -140,10 +404,67 @@ func IsSameOutputConfigFilters(c1, c2 *OutputConfig) bool {
 		return true
 	}
 
-	if c1 != nil || c2 != nil {
+	if c1 == nil || c2 == nil {
 		return false
 	}
 
 	return c1.Scale.Eq(&c2.Scale) &&
-		c1.SnapshotInterval == c2.SnapshotInterval
+		c1.SnapshotInterval == c2.SnapshotInterval &&
+		c1.Count == c2.Count &&
+		c1.ExactAlignment == c2.ExactAlignment &&
+		c1.KeyframeSnapping == c2.KeyframeSnapping &&
+		IsSameIntervalRules(c1.IntervalRules, c2.IntervalRules) &&
+		IsSameSceneConfig(c1.Scene, c2.Scene) &&
+		IsSameFilters(c1.PreFilters, c2.PreFilters) &&
+		IsSameFilters(c1.PostFilters, c2.PostFilters)
+}
+
+// IsSameIntervalRules check is two interval rules configurations equal
+func IsSameIntervalRules(r1, r2 *IntervalRules) bool {
+	if r1 == nil && r2 == nil {
+		return true
+	}
+
+	if r1 == nil || r2 == nil {
+		return false
+	}
+
+	return r1.MaxFrames == r2.MaxFrames && slices.Equal(r1.Rules, r2.Rules)
+}
+
+// IsSameSceneConfig check is two scene configurations equal
+func IsSameSceneConfig(c1, c2 *SceneConfig) bool {
+	if c1 == nil && c2 == nil {
+		return true
+	}
+
+	if c1 == nil || c2 == nil {
+		return false
+	}
+
+	return *c1 == *c2
+}
+
+// GetThreshold returns scene change threshold respecting the default value
+func (c *SceneConfig) GetThreshold() float64 {
+	if c.Threshold == 0 {
+		return DefaultSceneThreshold
+	}
+
+	return c.Threshold
+}
+
+// IsSameFilters check is two filter lists render to the same chain
+func IsSameFilters(f1, f2 []*Filter) bool {
+	if len(f1) != len(f2) {
+		return false
+	}
+
+	for i := range f1 {
+		if f1[i].String() != f2[i].String() {
+			return false
+		}
+	}
+
+	return true
 }
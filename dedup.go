@@ -0,0 +1,54 @@
+package ffthumbs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// DedupKeyFunc returns a key of the async request, requests with the same key submitted while the first one
+// is queued or running are attached to its job (see Config.DedupKey), empty key disables deduplication of the request
+type DedupKeyFunc func(req *GenerateRequest) string
+
+// DefaultDedupKey returns a key built from GenerateRequest.MediaURL, the processing window
+// and the resolved outputs destinations, it is used when Config.DedupKey is not set
+func (g *Generator) DefaultDedupKey(req *GenerateRequest) string {
+	hash := sha256.New()
+	g.writeRequestIdentity(hash, req)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeRequestIdentity writes the request media URL, processing window and resolved outputs destinations
+func (g *Generator) writeRequestIdentity(w io.Writer, req *GenerateRequest) {
+	fmt.Fprintf(w, "%q %d %d %d %v\n", req.MediaURL, req.Strategy, req.Start, req.End, req.Skip)
+
+	for _, output := range g.cfg.Outputs {
+		outputDst := req.getOutputDst(output)
+		fmt.Fprintf(w, "output %d %q\n", output.idx, outputDst)
+
+		if output.VTT != nil {
+			fmt.Fprintf(w, "vtt %q %q\n", req.getVTTDst(output, outputDst), req.VTTBaseURL[output.idx])
+		}
+
+		if output.Manifest != nil {
+			fmt.Fprintf(w, "manifest %q\n", req.getManifestDst(output, outputDst))
+		}
+	}
+}
+
+// dedupKey returns the request deduplication key, empty key is returned when deduplication is disabled
+func (g *Generator) dedupKey(req *GenerateRequest) string {
+	switch {
+	case !g.cfg.EnableDedup:
+		return ""
+	// The request cancellation and progress callback would be shared with the attached requests
+	case req.Context != nil || req.OnProgress != nil:
+		return ""
+	case g.cfg.DedupKey != nil:
+		return g.cfg.DedupKey(req)
+	default:
+		return g.DefaultDedupKey(req)
+	}
+}
@@ -0,0 +1,128 @@
+package ffthumbs_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/codercms/ffthumbs"
+)
+
+func TestGeneratorDedup(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  ffthumbs.Config
+		// wantJobs is a number of jobs created for the same media with the same and overridden destinations
+		wantJobs int
+	}{
+		{name: "disabled by default", wantJobs: 3},
+		{name: "enabled", cfg: ffthumbs.Config{EnableDedup: true}, wantJobs: 2},
+		{
+			name: "custom key",
+			cfg: ffthumbs.Config{
+				EnableDedup: true,
+				DedupKey: func(req *ffthumbs.GenerateRequest) string {
+					return req.MediaURL
+				},
+			},
+			wantJobs: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Concurrency = 3
+			gen, release := newBlockedGenerator(t, &tt.cfg)
+
+			done := make(chan *ffthumbs.GenerateResult, 3)
+			reqs := []*ffthumbs.GenerateRequest{
+				{MediaURL: "video.mp4", DoneChan: done},
+				{MediaURL: "video.mp4", DoneChan: done},
+				{MediaURL: "video.mp4", DoneChan: done, OutputDst: map[int]string{0: t.TempDir() + "/%04d.jpg"}},
+			}
+
+			jobs := make(map[*ffthumbs.Job]bool)
+
+			for _, req := range reqs {
+				job, err := gen.GenerateAsync(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if req.GetId() != job.ID() {
+					t.Errorf("request id %d differs from job id %d", req.GetId(), job.ID())
+				}
+
+				jobs[job] = true
+			}
+
+			if len(jobs) != tt.wantJobs {
+				t.Errorf("got %d jobs, want %d", len(jobs), tt.wantJobs)
+			}
+
+			close(release)
+
+			// Every caller gets the result of its own request
+			delivered := make(map[*ffthumbs.GenerateRequest]bool)
+
+			for range reqs {
+				res := <-done
+				if res.Err != nil {
+					t.Errorf("unexpected request error %v", res.Err)
+				}
+
+				if !slices.Contains(reqs, res.Req) || delivered[res.Req] {
+					t.Errorf("unexpected result request %+v", res.Req)
+				}
+
+				delivered[res.Req] = true
+
+				if job, ok := jobByID(jobs, res.Req.GetId()); !ok || job.Result().Outputs[0] != res.Outputs[0] {
+					t.Errorf("request %d result differs from its job result", res.Req.GetId())
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratorDedupOwnContext(t *testing.T) {
+	gen, release := newBlockedGenerator(t, &ffthumbs.Config{Concurrency: 3, EnableDedup: true})
+
+	done := make(chan *ffthumbs.GenerateResult, 3)
+	reqs := []*ffthumbs.GenerateRequest{
+		{MediaURL: "video.mp4", DoneChan: done},
+		{MediaURL: "video.mp4", DoneChan: done, Context: context.Background()},
+		{MediaURL: "video.mp4", DoneChan: done, OnProgress: func(*ffthumbs.GenerateRequest, ffthumbs.Progress) {}},
+	}
+
+	jobs := make(map[*ffthumbs.Job]bool)
+
+	for _, req := range reqs {
+		job, err := gen.GenerateAsync(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jobs[job] = true
+	}
+
+	if len(jobs) != len(reqs) {
+		t.Errorf("got %d jobs, want %d", len(jobs), len(reqs))
+	}
+
+	close(release)
+
+	for range reqs {
+		if res := <-done; res.Err != nil {
+			t.Errorf("unexpected request error %v", res.Err)
+		}
+	}
+}
+
+func jobByID(jobs map[*ffthumbs.Job]bool, id uint64) (*ffthumbs.Job, bool) {
+	for job := range jobs {
+		if job.ID() == id {
+			return job, true
+		}
+	}
+
+	return nil, false
+}
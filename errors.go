@@ -0,0 +1,268 @@
+package ffthumbs
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorKind is a classification of the GenerateError
+type ErrorKind int
+
+const (
+	ErrorKindUnknown ErrorKind = iota
+	// ErrorKindInputNotFound the media file doesn't exist
+	ErrorKindInputNotFound
+	// ErrorKindHTTPClient the media server replied with 4xx status
+	ErrorKindHTTPClient
+	// ErrorKindHTTPServer the media server replied with 5xx status
+	ErrorKindHTTPServer
+	// ErrorKindNetwork the media server connection failed, e.g. connection refused or reset
+	ErrorKindNetwork
+	// ErrorKindInvalidData the media is corrupted or isn't a media at all
+	ErrorKindInvalidData
+	// ErrorKindNoVideoStream the media has no video stream
+	ErrorKindNoVideoStream
+	// ErrorKindUnsupportedCodec ffmpeg has no decoder of the media codec
+	ErrorKindUnsupportedCodec
+	// ErrorKindOutputWrite outputs cannot be written, e.g. missing dir, permissions or no disk space
+	ErrorKindOutputWrite
+	// ErrorKindCancelled the request context was cancelled or its deadline exceeded
+	ErrorKindCancelled
+)
+
+// Sentinel errors matching GenerateError of the corresponding kind with errors.Is
+var (
+	ErrInputNotFound    = errors.New("input not found")
+	ErrHTTPClient       = errors.New("http client error")
+	ErrHTTPServer       = errors.New("http server error")
+	ErrNetwork          = errors.New("network error")
+	ErrInvalidData      = errors.New("invalid data")
+	ErrNoVideoStream    = errors.New("no video stream")
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrOutputWrite      = errors.New("output write failure")
+	ErrCancelled        = errors.New("cancelled")
+)
+
+// ErrGeneratorClosed is returned for requests made after Generator.Shutdown or Generator.Close,
+// requests cancelled by the shutdown match it as well
+var ErrGeneratorClosed = errors.New("generator is closed")
+
+// maxStderrTailSize limits stderr size kept in GenerateError
+const maxStderrTailSize = 4096
+
+var (
+	// httpStatusPattern matches ffmpeg http protocol errors, e.g. "Server returned 404 Not Found",
+	// "Server returned 5XX Server Error reply" or "HTTP error 503 Service Unavailable"
+	httpStatusPattern = regexp.MustCompile(`(?:Server returned|HTTP error) ([45])(\d\d|XX)`)
+
+	// stderrKindPatterns are checked in order, output patterns go before input ones because
+	// output failures are often reported along with "No such file or directory".
+	// "Error opening input" isn't a kind by itself, since ffmpeg 6.1 it prefixes any input failure
+	// (e.g. "Error opening input: Invalid data found when processing input")
+	stderrKindPatterns = []struct {
+		kind    ErrorKind
+		pattern *regexp.Regexp
+	}{
+		{ErrorKindOutputWrite, regexp.MustCompile(`Could not open file|Error opening output|Could not write header|av_interleaved_write_frame\(\)|Error muxing a packet|Error writing trailer|No space left on device`)},
+		{ErrorKindNetwork, regexp.MustCompile(`Connection refused|Connection reset by peer|Connection timed out|Network is unreachable|Failed to resolve hostname`)},
+		{ErrorKindInputNotFound, regexp.MustCompile(`No such file or directory`)},
+		{ErrorKindNoVideoStream, regexp.MustCompile(`matches no streams|does not contain any stream`)},
+		{ErrorKindUnsupportedCodec, regexp.MustCompile(`Decoder \(codec .*\) not found|Unsupported codec|no decoder found|Unknown decoder`)},
+		{ErrorKindInvalidData, regexp.MustCompile(`Invalid data found when processing input|moov atom not found`)},
+	}
+)
+
+// GenerateError describes a failed ffmpeg or ffprobe run, use errors.Is with sentinel errors (e.g. ErrHTTPServer)
+// or errors.As to check the failure kind
+type GenerateError struct {
+	Kind ErrorKind
+	// Cmd is a failed command name, e.g. "ffmpeg", empty when the failure isn't caused by a command
+	Cmd string
+	// ExitCode is the command exit code, -1 when the command didn't exit normally (e.g. was killed)
+	ExitCode int
+	// HTTPStatus is a media server reply status for ErrorKindHTTPClient and ErrorKindHTTPServer,
+	// 0 when ffmpeg reports only a status class (e.g. 5XX)
+	HTTPStatus int
+	// Stderr is the command stderr tail
+	Stderr string
+	// Err is an underlying error, e.g. *exec.ExitError or context.Canceled
+	Err error
+
+	// reason is the stderr line the kind was detected by
+	reason string
+}
+
+func (k ErrorKind) String() string {
+	if err := k.sentinel(); err != nil {
+		return err.Error()
+	}
+
+	return "unknown error"
+}
+
+func (k ErrorKind) sentinel() error {
+	switch k {
+	case ErrorKindInputNotFound:
+		return ErrInputNotFound
+	case ErrorKindHTTPClient:
+		return ErrHTTPClient
+	case ErrorKindHTTPServer:
+		return ErrHTTPServer
+	case ErrorKindNetwork:
+		return ErrNetwork
+	case ErrorKindInvalidData:
+		return ErrInvalidData
+	case ErrorKindNoVideoStream:
+		return ErrNoVideoStream
+	case ErrorKindUnsupportedCodec:
+		return ErrUnsupportedCodec
+	case ErrorKindOutputWrite:
+		return ErrOutputWrite
+	case ErrorKindCancelled:
+		return ErrCancelled
+	default:
+		return nil
+	}
+}
+
+func (e *GenerateError) Error() string {
+	var b strings.Builder
+
+	if len(e.Cmd) > 0 {
+		b.WriteString(e.Cmd)
+		b.WriteString(" failed: ")
+	}
+
+	b.WriteString(e.Kind.String())
+
+	if len(e.reason) > 0 {
+		b.WriteString(": ")
+		b.WriteString(e.reason)
+	}
+
+	if e.Err != nil {
+		b.WriteString(" (")
+		b.WriteString(e.Err.Error())
+		b.WriteString(")")
+	}
+
+	return b.String()
+}
+
+// Is matches the sentinel error of the error kind
+func (e *GenerateError) Is(target error) bool {
+	sentinel := e.Kind.sentinel()
+
+	return sentinel != nil && sentinel == target
+}
+
+func (e *GenerateError) Unwrap() error {
+	return e.Err
+}
+
+// newCommandError classifies failed command error by the command stderr, cancelled ctx takes precedence
+func newCommandError(ctx context.Context, cmdName string, err error, stderr string) *GenerateError {
+	genErr := &GenerateError{
+		Cmd:      cmdName,
+		ExitCode: -1,
+		Stderr:   stderrTail(stderr),
+		Err:      err,
+	}
+
+	var exitErr exitCoder
+	if errors.As(err, &exitErr) {
+		genErr.ExitCode = exitErr.ExitCode()
+	}
+
+	if ctx != nil && ctx.Err() != nil {
+		genErr.Kind = ErrorKindCancelled
+		genErr.Err = context.Cause(ctx)
+
+		return genErr
+	}
+
+	genErr.Kind, genErr.HTTPStatus, genErr.reason = classifyStderr(stderr)
+
+	return genErr
+}
+
+// newCancelledError returns cancelled request error, nil is returned when ctx isn't cancelled
+func newCancelledError(ctx context.Context) error {
+	if ctx == nil || ctx.Err() == nil {
+		return nil
+	}
+
+	return &GenerateError{
+		Kind:     ErrorKindCancelled,
+		ExitCode: -1,
+		Err:      context.Cause(ctx),
+	}
+}
+
+// classifyStderr detects error kind by ffmpeg/ffprobe stderr, the line the kind was detected by is returned
+func classifyStderr(stderr string) (kind ErrorKind, httpStatus int, reason string) {
+	lines := strings.Split(stderr, "\n")
+
+	for _, line := range lines {
+		match := httpStatusPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		kind = ErrorKindHTTPClient
+		if match[1] == "5" {
+			kind = ErrorKindHTTPServer
+		}
+
+		httpStatus, _ = strconv.Atoi(match[1] + match[2])
+
+		return kind, httpStatus, trimLogLine(line)
+	}
+
+	for _, p := range stderrKindPatterns {
+		for _, line := range lines {
+			if p.pattern.MatchString(line) {
+				return p.kind, 0, trimLogLine(line)
+			}
+		}
+	}
+
+	return ErrorKindUnknown, 0, ""
+}
+
+// trimLogLine removes log level tags added by the "level" loglevel flag
+func trimLogLine(line string) string {
+	for _, level := range []string{"[fatal] ", "[error] ", "[warning] "} {
+		line = strings.Replace(line, level, "", 1)
+	}
+
+	return strings.TrimSpace(line)
+}
+
+// stderrTail returns stderr tail of at most maxStderrTailSize bytes starting at the line beginning
+func stderrTail(stderr string) string {
+	stderr = strings.TrimSpace(stderr)
+
+	if len(stderr) <= maxStderrTailSize {
+		return stderr
+	}
+
+	tail := stderr[len(stderr)-maxStderrTailSize:]
+	if idx := strings.IndexByte(tail, '\n'); idx >= 0 {
+		tail = tail[idx+1:]
+	}
+
+	return tail
+}
+
+// wrapOutputWriteError classifies error of the outputs writing (e.g. VTT or manifest)
+func wrapOutputWriteError(err error) error {
+	return &GenerateError{
+		Kind:     ErrorKindOutputWrite,
+		ExitCode: -1,
+		Err:      err,
+	}
+}
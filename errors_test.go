@@ -0,0 +1,159 @@
+package ffthumbs
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestClassifyStderr(t *testing.T) {
+	tests := []struct {
+		name       string
+		stderr     string
+		kind       ErrorKind
+		httpStatus int
+		reason     string
+	}{
+		{
+			name:   "input not found",
+			stderr: "[error] /media/missing.mp4: No such file or directory\n",
+			kind:   ErrorKindInputNotFound,
+			reason: "/media/missing.mp4: No such file or directory",
+		},
+		{
+			name:   "input not found ffmpeg 7",
+			stderr: "[error] Error opening input file /media/missing.mp4.\n[error] Error opening input files: No such file or directory\n",
+			kind:   ErrorKindInputNotFound,
+			reason: "Error opening input files: No such file or directory",
+		},
+		{
+			name:   "invalid data ffmpeg 7",
+			stderr: "[in#0 @ 0x5581] [error] Error opening input: Invalid data found when processing input\n[error] Error opening input file /media/broken.mp4.\n[error] Error opening input files: Invalid data found when processing input\n",
+			kind:   ErrorKindInvalidData,
+			reason: "[in#0 @ 0x5581] Error opening input: Invalid data found when processing input",
+		},
+		{
+			name:       "http 404",
+			stderr:     "[https @ 0x55d1c8a3e4c0] [error] HTTP error 404 Not Found\n[error] https://cdn/v.mp4: Server returned 404 Not Found\n",
+			kind:       ErrorKindHTTPClient,
+			httpStatus: 404,
+			reason:     "[https @ 0x55d1c8a3e4c0] HTTP error 404 Not Found",
+		},
+		{
+			name:   "http 5xx class",
+			stderr: "[error] https://cdn/v.m3u8: Server returned 5XX Server Error reply\n",
+			kind:   ErrorKindHTTPServer,
+			reason: "https://cdn/v.m3u8: Server returned 5XX Server Error reply",
+		},
+		{
+			name:   "connection reset",
+			stderr: "[tls @ 0x55d1c8a3e4c0] [error] Error in the pull function.\n[error] https://cdn/v.mp4: Connection reset by peer\n",
+			kind:   ErrorKindNetwork,
+			reason: "https://cdn/v.mp4: Connection reset by peer",
+		},
+		{
+			name:   "invalid data",
+			stderr: "[mov,mp4,m4a,3gp,3g2,mj2 @ 0x5581] [error] moov atom not found\n[error] /media/broken.mp4: Invalid data found when processing input\n",
+			kind:   ErrorKindInvalidData,
+			reason: "[mov,mp4,m4a,3gp,3g2,mj2 @ 0x5581] moov atom not found",
+		},
+		{
+			name:   "no video stream",
+			stderr: "[fatal] Stream specifier ':v' in filtergraph description [0:v]fps=1[out] matches no streams.\n",
+			kind:   ErrorKindNoVideoStream,
+			reason: "Stream specifier ':v' in filtergraph description [0:v]fps=1[out] matches no streams.",
+		},
+		{
+			name:   "unsupported codec",
+			stderr: "[error] Decoder (codec av1) not found for input stream #0:0\n",
+			kind:   ErrorKindUnsupportedCodec,
+			reason: "Decoder (codec av1) not found for input stream #0:0",
+		},
+		{
+			name:   "output dir missing",
+			stderr: "[image2 @ 0x5581] [error] Could not open file : /out/missing/0001.jpg\n[error] av_interleaved_write_frame(): No such file or directory\n",
+			kind:   ErrorKindOutputWrite,
+			reason: "[image2 @ 0x5581] Could not open file : /out/missing/0001.jpg",
+		},
+		{
+			name:   "unknown",
+			stderr: "[error] Conversion failed!\n",
+			kind:   ErrorKindUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, httpStatus, reason := classifyStderr(tt.stderr)
+
+			if kind != tt.kind || httpStatus != tt.httpStatus || reason != tt.reason {
+				t.Errorf("got (%s, %d, %q), want (%s, %d, %q)", kind, httpStatus, reason, tt.kind, tt.httpStatus, tt.reason)
+			}
+		})
+	}
+}
+
+func TestGenerateErrorMatching(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 8").Run()
+
+	var err error = newCommandError(context.Background(), "ffmpeg", exitErr, "[error] https://cdn/v.mp4: Server returned 503 Service Unavailable\n")
+
+	if !errors.Is(err, ErrHTTPServer) || errors.Is(err, ErrHTTPClient) {
+		t.Errorf("unexpected kind match of %v", err)
+	}
+
+	var genErr *GenerateError
+	if !errors.As(err, &genErr) {
+		t.Fatalf("%v isn't GenerateError", err)
+	}
+
+	if genErr.ExitCode != 8 || genErr.HTTPStatus != 503 {
+		t.Errorf("unexpected exit code %d or http status %d", genErr.ExitCode, genErr.HTTPStatus)
+	}
+
+	var origExitErr *exec.ExitError
+	if !errors.As(err, &origExitErr) {
+		t.Errorf("exit error isn't unwrapped from %v", err)
+	}
+
+	want := "ffmpeg failed: http server error: https://cdn/v.mp4: Server returned 503 Service Unavailable (exit status 8)"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
+
+func TestGenerateErrorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := newCommandError(ctx, "ffmpeg", errors.New("signal: killed"), "[error] https://cdn/v.mp4: Connection reset by peer\n")
+
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) || errors.Is(err, ErrNetwork) {
+		t.Errorf("unexpected kind match of %v", err)
+	}
+
+	if err.ExitCode != -1 {
+		t.Errorf("unexpected exit code %d", err.ExitCode)
+	}
+
+	if newCancelledError(context.Background()) != nil {
+		t.Error("not cancelled ctx error")
+	}
+}
+
+func TestStderrTail(t *testing.T) {
+	line := strings.Repeat("x", 99) + "\n"
+	stderr := strings.Repeat(line, 100)
+
+	tail := stderrTail(stderr)
+
+	if len(tail) > maxStderrTailSize || !strings.HasPrefix(tail, "xxx") || strings.Count(tail, "\n") != maxStderrTailSize/len(line)-1 {
+		t.Errorf("unexpected tail of %d bytes: %q", len(tail), tail[:min(len(tail), 120)])
+	}
+
+	if got := stderrTail("short\n"); got != "short" {
+		t.Errorf("got %q", got)
+	}
+}
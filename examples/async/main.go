@@ -73,7 +73,7 @@ func main() {
 
 		// See https://github.com/golang/go/wiki/CommonMistakes
 		reqCopy := req
-		if err := thumbsGen.GenerateAsync(&reqCopy); err != nil {
+		if _, err := thumbsGen.GenerateAsync(&reqCopy); err != nil {
 			log.Fatalf("Unable to send generate thumbnails request: %v", err)
 		}
 
@@ -27,6 +27,8 @@ func main() {
 						Rows:    64,
 					},
 				},
+				// Also write sprites/thumbnails.vtt track for the web players (video.js, JW Player, Plyr)
+				VTT: &ffthumbs.VTTConfig{},
 			},
 		},
 	})
@@ -43,7 +45,7 @@ func main() {
 		MediaURL: examples.StreamURL,
 	}
 
-	if err := thumbsGen.Generate(&req); err != nil {
+	if _, err := thumbsGen.Generate(&req); err != nil {
 		log.Fatalf("Unable to generate thumbnails: %v", err)
 	}
 
@@ -37,7 +37,7 @@ func main() {
 		MediaURL: examples.StreamURL,
 	}
 
-	if err := thumbsGen.Generate(&req); err != nil {
+	if _, err := thumbsGen.Generate(&req); err != nil {
 		log.Fatalf("Unable to generate thumbnails: %v", err)
 	}
 
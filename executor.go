@@ -0,0 +1,154 @@
+package ffthumbs
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Executor launches ffmpeg and ffprobe processes, it allows to run them in a sandbox,
+	// a container or a different namespace, or to replace them with fakes in tests (see ffthumbstest package).
+	// Default: OSExecutor
+	Executor interface {
+		// LookPath resolves a binary name (e.g. "ffmpeg") into the path passed to Command
+		LookPath(file string) (string, error)
+		// Command prepares a process to run, the process must be cancelled (see Process.Cancel)
+		// when ctx is done, ctx could be nil
+		Command(ctx context.Context, path string, args ...string) Process
+	}
+
+	// Process is a single process run, the streams must be requested before Start
+	// and fully read before Wait
+	Process interface {
+		StdoutPipe() (io.ReadCloser, error)
+		StderrPipe() (io.ReadCloser, error)
+		Start() error
+		// Wait waits for the process exit, an error implementing ExitCode() int (e.g. *exec.ExitError)
+		// is expected when the process exits with non-zero code
+		Wait() error
+		// Cancel asks the started process to stop
+		Cancel() error
+		// String returns a human-readable command description for logs
+		String() string
+	}
+
+	// OSExecutor runs processes on the host with os/exec. Cancelled process is interrupted (SIGINT),
+	// so ffmpeg could finish the files being written, and after GracePeriod the whole process group is killed.
+	// On platforms without process groups and signals the process is killed.
+	OSExecutor struct {
+		// GracePeriod is a time given to the interrupted process to exit, 0 - kill immediately
+		GracePeriod time.Duration
+	}
+
+	osProcess struct {
+		cmd         *exec.Cmd
+		gracePeriod time.Duration
+
+		cancelOnce sync.Once
+		cancelled  atomic.Bool
+		killTimer  *time.Timer
+	}
+)
+
+// exitCoder is implemented by the errors of processes exited with non-zero code
+type exitCoder interface {
+	ExitCode() int
+}
+
+func (OSExecutor) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (e OSExecutor) Command(ctx context.Context, path string, args ...string) Process {
+	p := &osProcess{gracePeriod: e.GracePeriod}
+
+	if ctx == nil {
+		p.cmd = exec.Command(path, args...)
+	} else {
+		p.cmd = exec.CommandContext(ctx, path, args...)
+		p.cmd.Cancel = p.Cancel
+		// Pipes are closed and Wait returns even if the killed process children still hold them
+		p.cmd.WaitDelay = e.GracePeriod + time.Second
+	}
+
+	setProcessGroup(p.cmd)
+
+	return p
+}
+
+func (p *osProcess) StdoutPipe() (io.ReadCloser, error) {
+	return p.cmd.StdoutPipe()
+}
+
+func (p *osProcess) StderrPipe() (io.ReadCloser, error) {
+	return p.cmd.StderrPipe()
+}
+
+func (p *osProcess) Start() error {
+	return p.cmd.Start()
+}
+
+func (p *osProcess) Wait() error {
+	err := p.cmd.Wait()
+
+	// Children left behind by the cancelled process are killed along with the process group
+	if p.cancelled.Load() {
+		p.killTimer.Stop()
+		killProcessGroup(p.cmd)
+	}
+
+	return err
+}
+
+// Cancel interrupts the process and kills its process group after the grace period
+func (p *osProcess) Cancel() error {
+	if p.cmd.Process == nil {
+		return nil
+	}
+
+	var err error
+
+	p.cancelOnce.Do(func() {
+		p.killTimer = time.AfterFunc(p.gracePeriod, func() {
+			killProcessGroup(p.cmd)
+		})
+		p.cancelled.Store(true)
+
+		if p.gracePeriod > 0 {
+			if err = interruptProcess(p.cmd); err != nil {
+				err = killProcessGroup(p.cmd)
+			}
+		}
+	})
+
+	return err
+}
+
+func (p *osProcess) String() string {
+	return p.cmd.String()
+}
+
+// resolveCancelGracePeriod returns default grace period for 0 and no grace period for negative values
+func resolveCancelGracePeriod(gracePeriod time.Duration) time.Duration {
+	switch {
+	case gracePeriod == 0:
+		return DefaultCancelGracePeriod
+	case gracePeriod < 0:
+		return 0
+	default:
+		return gracePeriod
+	}
+}
+
+// getExecutor returns provided executor or OSExecutor with the provided grace period when nil
+func getExecutor(executor Executor, gracePeriod time.Duration) Executor {
+	if executor == nil {
+		return OSExecutor{GracePeriod: gracePeriod}
+	}
+
+	return executor
+}
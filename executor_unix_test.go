@@ -0,0 +1,105 @@
+//go:build unix
+
+package ffthumbs
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runCancelled launches sh script with OSExecutor, cancels it after the script printed its first line
+// and returns the first line, the rest of stdout and Wait error
+func runCancelled(t *testing.T, gracePeriod time.Duration, script string) (firstLine, rest string, err error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	proc := OSExecutor{GracePeriod: gracePeriod}.Command(ctx, "sh", "-c", script)
+
+	stdout, err := proc.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 0, 64)
+	for !strings.Contains(string(buf), "\n") {
+		chunk := make([]byte, 64)
+
+		n, err := stdout.Read(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf = append(buf, chunk[:n]...)
+	}
+
+	cancel()
+
+	tail, _ := io.ReadAll(stdout)
+	firstLine, rest, _ = strings.Cut(string(buf)+string(tail), "\n")
+
+	return firstLine, rest, proc.Wait()
+}
+
+// isProcessAlive tells whether the process is running, zombies are considered dead
+func isProcessAlive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+
+	_, fields, _ := strings.Cut(string(stat), ") ")
+
+	return !strings.HasPrefix(fields, "Z")
+}
+
+func TestOSExecutorGracefulCancel(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("procfs is not available")
+	}
+
+	// Background jobs of non-interactive shell ignore SIGINT, the child is left behind after the shell exits
+	childPID, rest, err := runCancelled(t, 5*time.Second, `trap 'echo interrupted; exit 3' INT; sleep 30 >/dev/null & echo $!; wait`)
+
+	if strings.TrimSpace(rest) != "interrupted" {
+		t.Errorf("process wasn't interrupted gracefully, stdout: %q", rest)
+	}
+
+	if exitErr, ok := err.(exitCoder); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	pid, _ := strconv.Atoi(childPID)
+
+	for i := 0; i < 50 && isProcessAlive(pid); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if isProcessAlive(pid) {
+		t.Errorf("child process %d is left behind", pid)
+	}
+}
+
+func TestOSExecutorKillAfterGracePeriod(t *testing.T) {
+	start := time.Now()
+
+	_, _, err := runCancelled(t, 100*time.Millisecond, `trap '' INT; echo started; sleep 30`)
+
+	if exitErr, ok := err.(exitCoder); !ok || exitErr.ExitCode() != -1 {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("process was killed after %s", elapsed)
+	}
+}
@@ -0,0 +1,121 @@
+package ffthumbs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// extractedFramePattern is an image sequence pattern of the frames extracted into the temp dir
+const extractedFramePattern = "%06d.png"
+
+// framesGroup is a select group of outputs which frames are extracted beforehand
+// (see GenerateStrategySeek and Config.Segments)
+type framesGroup struct {
+	selection frameSelection
+	// points are the planned seek points
+	points []time.Duration
+	// outputs are indexes of the group outputs
+	outputs []int
+}
+
+// planFramesGroups groups outputs for the frames extraction, seek points aren't planned
+func planFramesGroups(outputs []*OutputConfig, opts *graphOptions) []*framesGroup {
+	selectGroups := planOutputs(outputs, opts)
+	groups := make([]*framesGroup, 0, len(selectGroups))
+
+	for _, selectGroup := range selectGroups {
+		group := &framesGroup{selection: selectGroup.selection}
+
+		for _, scaleGroup := range selectGroup.scaleGroups {
+			for _, output := range scaleGroup.outputs {
+				group.outputs = append(group.outputs, output.idx)
+			}
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// makeTempDir creates temp dir for the extracted frames
+func (g *Generator) makeTempDir(pattern string, slogArgs []slog.Attr) (string, error) {
+	tmpDir, err := os.MkdirTemp(g.cfg.TempDir, pattern)
+	if err != nil {
+		args := slogArgs
+		args = append(args,
+			slog.String("err", err.Error()),
+		)
+
+		g.logger.LogAttrs(context.Background(), slog.LevelError, "cannot create temp dir", args...)
+
+		return "", fmt.Errorf("cannot create temp dir: %w", err)
+	}
+
+	return tmpDir, nil
+}
+
+// composeExtractedFrames composes outputs from the frames extracted into the select groups dirs of tmpDir,
+// groupsPTS are the extracted frames timestamps of each select group
+func (g *Generator) composeExtractedFrames(req *GenerateRequest, plan *requestPlan, tmpDir string, groupsPTS [][]time.Duration, slogArgs []slog.Attr) (*framesCollector, error) {
+	cmdArgs := slices.Clone(g.cmdArgs)
+
+	for i, pts := range groupsPTS {
+		if len(pts) == 0 {
+			err := fmt.Errorf("no frames extracted for select group %d", i)
+
+			args := slogArgs
+			args = append(args,
+				slog.String("err", err.Error()),
+			)
+
+			g.logger.LogAttrs(context.Background(), slog.LevelError, "frames extraction failed", args...)
+
+			return nil, err
+		}
+
+		groupDir := filepath.Join(tmpDir, buildSelectGroupName(i))
+
+		cmdArgs = append(cmdArgs, "-framerate", "1", "-i", filepath.Join(groupDir, extractedFramePattern))
+	}
+
+	cmdArgs = appendOutputArgs(cmdArgs, req, plan)
+
+	// Composing progress isn't reported, frames extraction is the most of the work
+	collector, err := g.runFfmpeg(plan.ctx, cmdArgs, nil, slogArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	collector.applyExtractedTimestamps(plan.framesGroups, groupsPTS)
+
+	return collector, nil
+}
+
+// applyExtractedTimestamps replaces timestamps of the composed frames with the timestamps of the extracted frames,
+// groupsPTS are the extracted frames timestamps of each frames group
+func (c *framesCollector) applyExtractedTimestamps(groups []*framesGroup, groupsPTS [][]time.Duration) {
+	outputGroups := map[int]int{}
+
+	for i, group := range groups {
+		for _, outputIdx := range group.outputs {
+			outputGroups[outputIdx] = i
+		}
+	}
+
+	for i := range c.frames {
+		frame := &c.frames[i]
+
+		groupIdx, ok := outputGroups[frame.outputIdx]
+		if !ok || frame.n >= len(groupsPTS[groupIdx]) {
+			continue
+		}
+
+		frame.pts = groupsPTS[groupIdx][frame.n]
+	}
+}
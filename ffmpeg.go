@@ -8,8 +8,9 @@ import (
 	"io"
 	"log/slog"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,32 +21,55 @@ import (
 )
 
 var (
-	progressPattern = regexp.MustCompile(`progress=([\w.]+)`)
-	outTimePattern  = regexp.MustCompile(`out_time=([^ ]+)`)
-	speedPattern    = regexp.MustCompile(`speed=([^ ]+)`)
-
 	versionPattern = regexp.MustCompile(`ffmpeg version ([0-9.]+)`)
 )
 
 type (
 	Generator struct {
-		ffmpegPath string
-		cmdArgs    []string
+		executor    Executor
+		ffmpegPath  string
+		ffprobePath string
+		// probeRequired is set when media duration is required to plan requests,
+		// otherwise it is only used for the progress percent and probe failures aren't fatal
+		probeRequired bool
+		// cmdArgs are the common ffmpeg args
+		cmdArgs []string
+		// inputArgs are the ffmpeg args of the media input
+		inputArgs []string
+		probeArgs []string
 
 		cfg *Config
 
 		logger *slog.Logger
 
 		pool *ants.PoolWithFunc
+		// queue holds async requests waiting for a free pool worker
+		queue *requestQueue
+
+		// ctx is the parent context of requests, it is cancelled when shutdown deadline exceeds
+		ctx    context.Context
+		cancel context.CancelCauseFunc
 
-		wg sync.WaitGroup
+		// mu guards closed flag against concurrent requests accounting in wg,
+		// pending is a number of the accounted requests which aren't released yet
+		mu      sync.RWMutex
+		closed  bool
+		wg      sync.WaitGroup
+		pending atomic.Int64
 
 		lastReqId atomic.Uint64
+
+		// jobs are the queued and running async requests by id, inflight are the same jobs by deduplication key
+		jobsMu   sync.Mutex
+		jobs     map[uint64]*Job
+		inflight map[string]*Job
 	}
 
 	GenerateRequest struct {
 		// id internal request id used for async processing
 		id uint64
+		// job is a handle of the async request
+		job *Job
 
 		// MediaURL path to media file (can be either a network path or a local fs path)
 		MediaURL string
@@ -54,6 +78,43 @@ type (
 		// map format is an output index => dest path
 		OutputDst map[int]string
 
+		// VTTDst allows to override VTTConfig.DstPath
+		// map format is an output index => dest path
+		VTTDst map[int]string
+
+		// VTTBaseURL allows to override VTTConfig.BaseURL
+		// map format is an output index => base url
+		VTTBaseURL map[int]string
+
+		// ManifestDst allows to override ManifestConfig.DstPath
+		// map format is an output index => dest path
+		ManifestDst map[int]string
+
+		// Strategy allows to override Config.Strategy
+		Strategy GenerateStrategy
+
+		// OnProgress allows to override Config.OnProgress
+		OnProgress ProgressFunc
+
+		// Start limits processing to the media part starting at Start
+		Start time.Duration
+
+		// End limits processing to the media part ending at End, 0 - until the end of the media
+		End time.Duration
+
+		// Skip is a list of excluded ranges (e.g. intros, ad breaks, credits), no frames are taken from them,
+		// applies to all outputs
+		Skip []TimeRange
+
+		// Priority orders async requests waiting for a free worker, higher priority requests are dispatched first,
+		// waiting requests gain priority over time (see Config.PriorityAging), default: 0
+		Priority int
+
+		// Deadline orders async requests of the same priority waiting for a free worker,
+		// the earliest deadline is dispatched first, requests without a deadline go last.
+		// Deadline doesn't limit processing, use Context for that
+		Deadline time.Time
+
 		// Context is used to cancel command
 		Context context.Context
 
@@ -71,6 +132,14 @@ type (
 		Err error
 		// Duration measures how much time was spent to process Req
 		Duration time.Duration
+		// Outputs describes frames written to each output, ordered as Config.Outputs
+		Outputs []*OutputResult
+		// Attempts is a number of processing attempts made (see Config.RetryPolicy)
+		Attempts int
+		// AttemptErrors are errors of the failed attempts
+		AttemptErrors []error
+		// Cached tells that Outputs are taken from Config.Cache and no files were written
+		Cached bool
 	}
 )
 
@@ -79,13 +148,50 @@ func (r *GenerateRequest) GetId() uint64 {
 	return r.id
 }
 
+// getOutputDst returns output destination path respecting OutputDst override
+func (r *GenerateRequest) getOutputDst(output *OutputConfig) string {
+	if dst, ok := r.OutputDst[output.idx]; ok {
+		return dst
+	}
+
+	return output.DstPath
+}
+
+// getVTTDst returns WebVTT track destination path respecting VTTDst override
+func (r *GenerateRequest) getVTTDst(output *OutputConfig, outputDst string) string {
+	if dst, ok := r.VTTDst[output.idx]; ok {
+		return dst
+	}
+
+	if len(output.VTT.DstPath) > 0 {
+		return output.VTT.DstPath
+	}
+
+	return filepath.Join(filepath.Dir(outputDst), DefaultVTTFilename)
+}
+
+// getManifestDst returns JSON manifest destination path respecting ManifestDst override
+func (r *GenerateRequest) getManifestDst(output *OutputConfig, outputDst string) string {
+	if dst, ok := r.ManifestDst[output.idx]; ok {
+		return dst
+	}
+
+	if len(output.Manifest.DstPath) > 0 {
+		return output.Manifest.DstPath
+	}
+
+	return filepath.Join(filepath.Dir(outputDst), DefaultManifestFilename)
+}
+
 // NewGenerator constructs new Generator based on provided config
 func NewGenerator(cfg *Config) (*Generator, error) {
 	if cfg == nil {
 		return nil, errors.New("nil cfg passed")
 	}
 
-	ffmpegPath, err := getVerifiedFfmpegPath(cfg.FfmpegPath)
+	executor := getExecutor(cfg.Executor, cfg.getCancelGracePeriod())
+
+	ffmpegPath, err := getVerifiedFfmpegPath(executor, cfg.FfmpegPath)
 	if err != nil {
 		return nil, err
 	}
@@ -95,32 +201,107 @@ func NewGenerator(cfg *Config) (*Generator, error) {
 		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	}
 
-	cmdArgs := []string{"-loglevel", "error"}
+	// showinfo filter reports frames with the info log level, "level" flag allows to distinguish errors
+	cmdArgs := []string{"-hide_banner", "-nostats", "-loglevel", "level+info"}
+	var inputArgs, probeArgs []string
 
 	if len(cfg.Headers) > 0 {
 		headersStr := BuildHeadersStr(cfg.Headers)
-		cmdArgs = append(cmdArgs, "-headers", headersStr)
+		inputArgs = append(inputArgs, "-headers", headersStr)
+		probeArgs = append(probeArgs, "-headers", headersStr)
 	}
 
+	// Keyframes nearest to the interval boundaries are looked up with ffprobe
+	needProbe := cfg.KeyframesOnly
+
+	var hasCustomFilters bool
+
 	for idx, output := range cfg.Outputs {
 		output.idx = idx
 		if len(output.DstPath) == 0 {
 			output.DstPath = DefaultFilename
 		}
+
+		if output.VTT != nil {
+			needProbe = true
+		}
+
+		if output.Count > 0 || output.IntervalRules != nil {
+			cfg.dynamicOutputs = true
+			needProbe = true
+		}
+
+		if len(output.PreFilters) > 0 || len(output.PostFilters) > 0 {
+			hasCustomFilters = true
+		}
 	}
 
-	filtersStr, err := BuildComplexFilters(cfg.Outputs)
-	if err != nil {
+	var ffprobePath string
+
+	probeRequired := needProbe || cfg.Strategy == GenerateStrategySeek || cfg.Segments > 1
+
+	if probeRequired {
+		ffprobePath, err = getVerifiedFfprobePath(executor, cfg.FfprobePath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Seeking and progress percent require media duration, outputs are decoded as a whole
+		// and progress percent is unknown when ffprobe isn't available
+		ffprobePath, _ = getVerifiedFfprobePath(executor, cfg.FfprobePath)
+
+		probeRequired = len(ffprobePath) > 0 && cfg.Strategy == GenerateStrategyAuto &&
+			isSeekable(cfg.Outputs, cfg.getSeekMinInterval(), false)
+	}
+
+	if err := validateConfig(cfg); err != nil {
 		return nil, err
 	}
 
-	cfg.filtersStr = filtersStr
+	// Dynamic outputs and keyframes only mode filters are built per request
+	if cfg.dynamicOutputs || cfg.KeyframesOnly {
+		if err := validateOutputs(cfg.Outputs); err != nil {
+			return nil, err
+		}
+	} else {
+		graph, err := buildFilterGraph(cfg.Outputs, cfg.graphOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.filtersStr = graph.String()
+	}
+
+	if cfg.Cache != nil {
+		cfg.outputsHash, err = hashOutputsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if hasCustomFilters {
+		availableFilters, err := getFfmpegFilters(executor, ffmpegPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateOutputsFiltersAvailability(cfg.Outputs, availableFilters); err != nil {
+			return nil, err
+		}
+	}
 
 	gen := &Generator{
-		ffmpegPath: ffmpegPath,
-		cmdArgs:    cmdArgs,
-		cfg:        cfg,
-		logger:     logger,
+		executor:      executor,
+		ffmpegPath:    ffmpegPath,
+		ffprobePath:   ffprobePath,
+		probeRequired: probeRequired,
+		cmdArgs:       cmdArgs,
+		inputArgs:     inputArgs,
+		probeArgs:     probeArgs,
+		cfg:           cfg,
+		logger:        logger,
+		jobs:          make(map[uint64]*Job),
+		inflight:      make(map[string]*Job),
 	}
 
 	concurrency := cfg.Concurrency
@@ -134,6 +315,8 @@ func NewGenerator(cfg *Config) (*Generator, error) {
 	}
 
 	gen.pool = pool
+	gen.queue = newRequestQueue(cfg.QueueSize, cfg.getPriorityAging())
+	gen.ctx, gen.cancel = context.WithCancelCause(context.Background())
 
 	return gen, nil
 }
@@ -151,6 +334,7 @@ func (g *Generator) SetConcurrency(newConcurrency int) {
 	}
 
 	g.pool.Tune(newConcurrency)
+	g.dispatchQueued()
 }
 
 // Wait waits until all requests processed
@@ -158,43 +342,177 @@ func (g *Generator) Wait() {
 	g.wg.Wait()
 }
 
-func (g *Generator) handleRequest(reqRaw any) {
-	req := reqRaw.(*GenerateRequest)
+// Shutdown stops accepting new requests (ErrGeneratorClosed is returned) and waits until accepted requests
+// are processed, when ctx is done earlier the remaining requests are cancelled and ctx error is returned
+// after they exit. Nil is returned when nothing is left to cancel, even if ctx is already done.
+// The worker pool is released in both cases.
+func (g *Generator) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
 
-	var timeStart time.Time
-	if req.DoneChan != nil {
-		timeStart = time.Now()
-	}
+	done := make(chan struct{})
 
-	err := g.Generate(req)
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
 
-	if req.DoneChan != nil {
-		res := GenerateResult{
-			Req:      req,
-			Err:      err,
-			Duration: time.Since(timeStart),
+	var err error
+
+	// No request is accounted after closing, so drained generator doesn't wait for done
+	if g.pending.Load() > 0 {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			select {
+			case <-done:
+			default:
+				err = ctx.Err()
+				g.cancel(ErrGeneratorClosed)
+			}
 		}
-
-		req.DoneChan <- &res
 	}
+
+	<-done
+
+	g.cancel(ErrGeneratorClosed)
+	g.pool.Release()
+
+	return err
 }
 
-// GenerateAsync is an asynchronous thumbnails generation using the underlying goroutine pool.
-// Concurrency is limited by Config.Concurrency.
-// When all goroutines in pool are busy this method would block until a free goroutine is available.
-//
-// Each request passed to this method will get unique identifier, you can get it by calling GenerateRequest.GetId().
-func (g *Generator) GenerateAsync(req *GenerateRequest) error {
-	req.id = g.lastReqId.Add(1)
+// Close stops accepting new requests, cancels accepted requests, waits until they exit and releases the worker pool,
+// context.Canceled is returned when accepted requests were cancelled (see Shutdown)
+func (g *Generator) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	return g.pool.Invoke(req)
+	return g.Shutdown(ctx)
 }
 
-// Generate is a blocking thumbnails generation, if you want to go async see GenerateAsync
-func (g *Generator) Generate(req *GenerateRequest) error {
+// acquire accounts a new request, false is returned when the generator is closed
+func (g *Generator) acquire() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.closed {
+		return false
+	}
+
 	g.wg.Add(1)
-	defer g.wg.Done()
+	g.pending.Add(1)
+
+	return true
+}
+
+// release releases the request accounted by acquire
+func (g *Generator) release() {
+	g.pending.Add(-1)
+	g.wg.Done()
+}
+
+// Generate is a blocking thumbnails generation, if you want to go async see GenerateAsync.
+// Result is always returned, result's Err is the same as returned error.
+// Generate used to return only error, callers which don't need the result should ignore it (see CHANGELOG.md).
+// ErrGeneratorClosed is returned after Shutdown or Close.
+func (g *Generator) Generate(req *GenerateRequest) (*GenerateResult, error) {
+	if !g.acquire() {
+		return &GenerateResult{Req: req, Err: ErrGeneratorClosed}, ErrGeneratorClosed
+	}
+	defer g.release()
+
+	// The request could be processed asynchronously before
+	req.job = nil
+
+	res := g.generateRequest(req)
+
+	return res, res.Err
+}
+
+// generateRequest processes accounted request
+func (g *Generator) generateRequest(req *GenerateRequest) *GenerateResult {
+	timeStart := time.Now()
+
+	// Request is cancelled along with its own context or on the generator shutdown
+	parentCtx := req.Context
+	if parentCtx == nil {
+		parentCtx = context.Background()
+	}
 
+	ctx, cancel := context.WithCancelCause(parentCtx)
+	defer cancel(nil)
+
+	stop := context.AfterFunc(g.ctx, func() {
+		cancel(context.Cause(g.ctx))
+	})
+	defer stop()
+
+	if req.job != nil {
+		req.job.start(cancel)
+	}
+
+	res := &GenerateResult{
+		Req: req,
+	}
+
+	slogArgs := req.LogArgs
+	if req.id > 0 {
+		slogArgs = append(slogArgs, slog.Uint64("req", req.id))
+	}
+
+	var cacheKey string
+	if g.cfg.Cache != nil {
+		if cacheKey = g.loadCachedResult(ctx, req, res, slogArgs); res.Cached {
+			res.Duration = time.Since(timeStart)
+
+			return res
+		}
+	}
+
+	res.Err = runWithRetry(ctx, g.cfg.RetryPolicy, g.logger, slogArgs, func() error {
+		var snapshot *outputsSnapshot
+		var err error
+
+		res.Attempts++
+		res.Outputs, snapshot, err = g.generate(ctx, req)
+
+		if err != nil {
+			res.AttemptErrors = append(res.AttemptErrors, err)
+
+			// Nothing is written when the attempt failed before ffmpeg was launched
+			if snapshot != nil && g.shouldRemoveAttemptOutputs(res.Attempts, err) {
+				snapshot.removeWritten()
+			}
+		}
+
+		return err
+	})
+
+	if res.Err == nil && cacheKey != "" {
+		g.storeResult(ctx, cacheKey, req, res.Outputs, slogArgs)
+	}
+
+	res.Duration = time.Since(timeStart)
+
+	return res
+}
+
+// shouldRemoveAttemptOutputs tells whether outputs of the attempt number attempt failed with err should be removed:
+// outputs of the cancelled requests are removed unless Config.KeepCancelledOutputs is set,
+// outputs of the other failed attempts are removed only when the request is retried
+func (g *Generator) shouldRemoveAttemptOutputs(attempt int, err error) bool {
+	if errors.Is(err, ErrCancelled) {
+		return !g.cfg.KeepCancelledOutputs
+	}
+
+	_, retry := g.cfg.RetryPolicy.nextBackoff(attempt, err)
+
+	return retry
+}
+
+// generate runs the request attempt, snapshot of the outputs destinations is returned once ffmpeg is about to be launched
+func (g *Generator) generate(ctx context.Context, req *GenerateRequest) ([]*OutputResult, *outputsSnapshot, error) {
 	logCtx := context.Background()
 	slogArgs := req.LogArgs
 
@@ -202,38 +520,136 @@ func (g *Generator) Generate(req *GenerateRequest) error {
 		slogArgs = append(slogArgs, slog.Uint64("req", req.id))
 	}
 
-	cmdArgs := g.cmdArgs
+	if err := newCancelledError(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := g.planRequest(ctx, req, slogArgs)
+	if err != nil {
+		args := slogArgs
+		args = append(args,
+			slog.String("err", err.Error()),
+		)
+
+		g.logger.LogAttrs(logCtx, slog.LevelError, "request planning failed", args...)
+
+		return nil, nil, err
+	}
+
+	plan.progress = g.newProgressTracker(req, plan, slogArgs)
+	snapshot := snapshotOutputs(req, plan.outputs)
+
+	var collector *framesCollector
+
+	switch {
+	case plan.strategy == GenerateStrategySeek:
+		collector, err = g.generateSeek(req, plan, slogArgs)
+	case len(plan.segments) > 0:
+		collector, err = g.generateSegments(req, plan, slogArgs)
+	default:
+		collector, err = g.generateDecodeAll(req, plan, slogArgs)
+	}
+
+	if err != nil {
+		return nil, snapshot, err
+	}
+
+	results, err := buildOutputResults(req, plan, collector)
+	if err != nil {
+		args := slogArgs
+		args = append(args,
+			slog.String("err", err.Error()),
+		)
+
+		g.logger.LogAttrs(logCtx, slog.LevelError, "output results building failed", args...)
+
+		return nil, snapshot, err
+	}
+
+	if err := writeVTTTracks(req, plan, results); err != nil {
+		args := slogArgs
+		args = append(args,
+			slog.String("err", err.Error()),
+		)
+
+		g.logger.LogAttrs(logCtx, slog.LevelError, "vtt track generation failed", args...)
+
+		return results, snapshot, wrapOutputWriteError(err)
+	}
+
+	if err := writeManifests(req, plan, results); err != nil {
+		args := slogArgs
+		args = append(args,
+			slog.String("err", err.Error()),
+		)
+
+		g.logger.LogAttrs(logCtx, slog.LevelError, "manifest writing failed", args...)
+
+		return results, snapshot, wrapOutputWriteError(err)
+	}
+
+	plan.progress.finish()
+
+	return results, snapshot, nil
+}
+
+// generateDecodeAll decodes the whole media stream and selects frames with the select filter
+func (g *Generator) generateDecodeAll(req *GenerateRequest, plan *requestPlan, slogArgs []slog.Attr) (*framesCollector, error) {
+	cmdArgs := slices.Clone(g.cmdArgs)
+	cmdArgs = append(cmdArgs, g.inputArgs...)
+
+	if g.cfg.KeyframesOnly {
+		cmdArgs = append(cmdArgs, "-skip_frame", "nokey")
+	}
+
+	cmdArgs = append(cmdArgs, plan.inputWindowArgs(TimeRange{})...)
 	cmdArgs = append(cmdArgs, "-i", req.MediaURL)
-	cmdArgs = append(cmdArgs, "-filter_complex", g.cfg.filtersStr)
+	cmdArgs = appendOutputArgs(cmdArgs, req, plan)
+
+	collector, err := g.runFfmpeg(plan.ctx, cmdArgs, plan.progress.partListener(0), slogArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Input timestamps are shifted to the window start
+	if plan.start > 0 {
+		for i := range collector.frames {
+			collector.frames[i].pts += plan.start
+		}
+	}
+
+	return collector, nil
+}
+
+// appendOutputArgs appends filters and outputs args of the request plan
+func appendOutputArgs(cmdArgs []string, req *GenerateRequest, plan *requestPlan) []string {
+	cmdArgs = append(cmdArgs, "-filter_complex", plan.filtersStr)
 	cmdArgs = append(cmdArgs, "-vsync", "0")
 
-	for _, output := range g.cfg.Outputs {
+	for _, output := range plan.outputs {
 		cmdArgs = append(cmdArgs, "-map", fmt.Sprintf("[%s]", output.outName))
 
 		if output.Quality > 0 {
 			cmdArgs = append(cmdArgs, "-q:v", strconv.Itoa(output.Quality))
 		}
 
-		outputDst := output.DstPath
-		if dstFolder, ok := req.OutputDst[output.idx]; ok {
-			outputDst = dstFolder
-		}
-
-		cmdArgs = append(cmdArgs, outputDst)
+		cmdArgs = append(cmdArgs, req.getOutputDst(output))
 	}
 
-	if !g.cfg.DisableProgressLogs {
-		cmdArgs = append(cmdArgs, "-progress", "pipe:1")
-	}
+	return cmdArgs
+}
 
-	var cmd *exec.Cmd
+// runFfmpeg launches ffmpeg and waits for its completion, frames reported by the filters
+// to the ffmpeg log are returned. onProgress receives ffmpeg progress updates when set.
+func (g *Generator) runFfmpeg(ctx context.Context, cmdArgs []string, onProgress func(Progress), slogArgs []slog.Attr) (*framesCollector, error) {
+	logCtx := context.Background()
 
-	if req.Context != nil {
-		cmd = exec.CommandContext(req.Context, g.ffmpegPath, cmdArgs...)
-	} else {
-		cmd = exec.Command(g.ffmpegPath, cmdArgs...)
+	if onProgress != nil {
+		cmdArgs = append(cmdArgs, "-progress", "pipe:1")
 	}
 
+	cmd := g.executor.Command(ctx, g.ffmpegPath, cmdArgs...)
+
 	{
 		args := slogArgs
 		args = append(args,
@@ -245,12 +661,12 @@ func (g *Generator) Generate(req *GenerateRequest) error {
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	start := time.Now()
@@ -263,34 +679,57 @@ func (g *Generator) Generate(req *GenerateRequest) error {
 
 		g.logger.LogAttrs(logCtx, slog.LevelError, "ffmpeg start failed", args...)
 
-		return err
+		return nil, newCommandError(ctx, "ffmpeg", err, "")
 	}
 
-	// Read stderr (error) log
+	// Read stderr (error) log and frames reported by showinfo filters
 	var stdErrLog strings.Builder
+	collector := &framesCollector{}
+	stderrDone := make(chan struct{})
+
 	go func() {
+		defer close(stderrDone)
+
 		scanner := bufio.NewScanner(stderr)
 
 		for scanner.Scan() {
-			stdErrLog.Write(scanner.Bytes())
+			line := scanner.Text()
+
+			if collector.parseLine(line) {
+				continue
+			}
+
+			if strings.Contains(line, "[info]") {
+				continue
+			}
+
+			stdErrLog.WriteString(line)
 			stdErrLog.WriteString("\n")
 		}
 	}()
 
-	if !g.cfg.DisableProgressLogs {
-		g.listenForProgressLogs(stdout, slogArgs)
+	if onProgress != nil {
+		listenForProgress(stdout, onProgress)
+	} else {
+		io.Copy(io.Discard, stdout)
 	}
 
+	// All reads from the pipes must be completed before cmd.Wait call
+	<-stderrDone
+
 	if err := cmd.Wait(); err != nil {
+		err := newCommandError(ctx, "ffmpeg", err, stdErrLog.String())
+
 		args := slogArgs
 		args = append(args,
 			slog.String("stderr", stdErrLog.String()),
 			slog.String("err", err.Error()),
+			slog.String("kind", err.Kind.String()),
 		)
 
 		g.logger.LogAttrs(logCtx, slog.LevelError, "ffmpeg run failed", args...)
 
-		return err
+		return nil, err
 	}
 
 	{
@@ -299,42 +738,94 @@ func (g *Generator) Generate(req *GenerateRequest) error {
 		g.logger.LogAttrs(logCtx, slog.LevelInfo, "ffmpeg command finished", args...)
 	}
 
-	return nil
+	return collector, nil
 }
 
-func (g *Generator) listenForProgressLogs(stdout io.Reader, slogArgs []slog.Attr) {
-	scanner := bufio.NewScanner(stdout)
+// buildOutputResults maps frames reported by ffmpeg to the outputs
+func buildOutputResults(req *GenerateRequest, plan *requestPlan, collector *framesCollector) ([]*OutputResult, error) {
+	framesByOutput := make([][]capturedFrame, len(plan.outputs))
 
-	var progress, currTime, speed string
-	var progressChanged bool
+	for _, frame := range collector.frames {
+		if frame.outputIdx < 0 || frame.outputIdx >= len(framesByOutput) {
+			continue
+		}
+
+		framesByOutput[frame.outputIdx] = append(framesByOutput[frame.outputIdx], frame)
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	results := make([]*OutputResult, 0, len(plan.outputs))
 
-		if match := progressPattern.FindStringSubmatch(line); len(match) > 1 {
-			progress = match[1]
-			progressChanged = true
+	for _, output := range plan.outputs {
+		res, err := buildOutputResult(
+			output,
+			req.getOutputDst(output),
+			framesByOutput[output.idx],
+			collector.sceneScores[output.idx],
+		)
+		if err != nil {
+			return nil, err
 		}
 
-		if match := outTimePattern.FindStringSubmatch(line); len(match) > 1 {
-			currTime = match[1]
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// writeManifests writes JSON frames manifests for outputs which have ManifestConfig
+func writeManifests(req *GenerateRequest, plan *requestPlan, results []*OutputResult) error {
+	for _, output := range plan.outputs {
+		if output.Manifest == nil {
+			continue
 		}
 
-		if match := speedPattern.FindStringSubmatch(line); len(match) > 1 {
-			speed = match[1]
+		dst := req.getManifestDst(output, req.getOutputDst(output))
+
+		if err := writeManifestFile(dst, results[output.idx]); err != nil {
+			return err
 		}
+	}
 
-		if progressChanged {
-			progressChanged = false
+	return nil
+}
+
+// writeVTTTracks writes WebVTT thumbnails tracks for outputs which have VTTConfig
+func writeVTTTracks(req *GenerateRequest, plan *requestPlan, results []*OutputResult) error {
+	for _, output := range plan.outputs {
+		if output.VTT == nil {
+			continue
+		}
 
-			args := slogArgs
-			args = append(args,
-				slog.String("progress", progress),
-				slog.String("time", currTime),
-				slog.String("speed", speed),
-			)
+		spriteDst := req.getOutputDst(output)
+		vttDst := req.getVTTDst(output, spriteDst)
 
-			g.logger.LogAttrs(context.Background(), slog.LevelInfo, "Progress update", args...)
+		baseURL := output.VTT.BaseURL
+		if reqBaseURL, ok := req.VTTBaseURL[output.idx]; ok {
+			baseURL = reqBaseURL
+		}
+
+		params := &spriteVTTParams{
+			BaseURL:        baseURL,
+			URLTemplate:    output.VTT.URLTemplate,
+			TilesPerSprite: output.Sprites.Dimensions.Columns * output.Sprites.Dimensions.Rows,
+			VTTDir:         filepath.Dir(vttDst),
+			Interval:       output.SnapshotInterval,
+			Duration:       plan.windowEnd(),
+		}
+
+		if plan.isAligned(output) {
+			params.Aligned = true
+			params.Start = plan.start
+		} else if plan.isTimestampBased(output) {
+			params.Interval = 0
+		}
+
+		cues := buildSpriteVTTCues(params, results[output.idx].Frames)
+
+		if err := writeVTTFile(vttDst, cues); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
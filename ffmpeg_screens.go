@@ -7,10 +7,9 @@ import (
 	"github.com/panjf2000/ants/v2"
 	"log/slog"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type TimeUnitType int
@@ -30,14 +29,25 @@ type (
 		Headers map[string]string
 		// Logger set pre-configured logger if you have one, default: json logger to stdout with debug log level
 		Logger *slog.Logger
+		// Executor launches ffmpeg and ffprobe processes, default: OSExecutor
+		Executor Executor
+		// CancelGracePeriod is a time given to ffmpeg to exit after ScreenshotsRequest.Context cancellation
+		// interrupted it, only applied to the default executor,
+		// default: DefaultCancelGracePeriod, negative value - kill immediately
+		CancelGracePeriod time.Duration
+		// RetryPolicy configures retries of ffmpeg and ffprobe runs failed with transient errors,
+		// default: nil (no retries)
+		RetryPolicy *RetryPolicy
 
 		filtersStr string
 	}
 
 	ScreenGenerator struct {
+		executor    Executor
 		ffmpegPath  string
 		ffprobePath string
 		cmdArgs     []string
+		probeArgs   []string
 
 		cfg *ScreensConfig
 
@@ -84,12 +94,18 @@ func NewScreensGenerator(cfg *ScreensConfig) (*ScreenGenerator, error) {
 		return nil, errors.New("nil cfg passed")
 	}
 
-	ffmpegPath, err := getVerifiedFfmpegPath(cfg.FfmpegPath)
+	if err := validateRetryPolicy(cfg.RetryPolicy); err != nil {
+		return nil, err
+	}
+
+	executor := getExecutor(cfg.Executor, resolveCancelGracePeriod(cfg.CancelGracePeriod))
+
+	ffmpegPath, err := getVerifiedFfmpegPath(executor, cfg.FfmpegPath)
 	if err != nil {
 		return nil, err
 	}
 
-	ffprobePath, err := getVerifiedFfprobePath(cfg.FfprobePath)
+	ffprobePath, err := getVerifiedFfprobePath(executor, cfg.FfprobePath)
 	if err != nil {
 		return nil, err
 	}
@@ -100,10 +116,12 @@ func NewScreensGenerator(cfg *ScreensConfig) (*ScreenGenerator, error) {
 	}
 
 	cmdArgs := []string{"-loglevel", "error"}
+	var probeArgs []string
 
 	if len(cfg.Headers) > 0 {
 		headersStr := BuildHeadersStr(cfg.Headers)
 		cmdArgs = append(cmdArgs, "-headers", headersStr)
+		probeArgs = append(probeArgs, "-headers", headersStr)
 	}
 
 	//filtersStr, err := BuildComplexFilters(cfg.Outputs)
@@ -112,9 +130,11 @@ func NewScreensGenerator(cfg *ScreensConfig) (*ScreenGenerator, error) {
 	}
 
 	gen := &ScreenGenerator{
+		executor:    executor,
 		ffmpegPath:  ffmpegPath,
 		ffprobePath: ffprobePath,
 		cmdArgs:     cmdArgs,
+		probeArgs:   probeArgs,
 		cfg:         cfg,
 		logger:      logger,
 	}
@@ -123,32 +143,12 @@ func NewScreensGenerator(cfg *ScreensConfig) (*ScreenGenerator, error) {
 }
 
 func (g *ScreenGenerator) getDuration(req *ScreenshotsRequest) (float64, error) {
-	cmd, err := launchCommand(launchParams{
-		ctx:  req.Context,
-		path: g.ffprobePath,
-		args: []string{
-			"-v", "error",
-			"-show_entries",
-			"format=duration",
-			"-of",
-			"default=noprint_wrappers=1:nokey=1",
-			req.MediaURL,
-		},
-		needStdout: true,
-		logger:     g.logger,
-		LogArgs:    req.LogArgs,
-	})
-
+	media, err := g.probe(req.Context, req.MediaURL, req.LogArgs)
 	if err != nil {
 		return 0, err
 	}
 
-	duration, err := strconv.ParseFloat(strings.TrimSpace(cmd.Stdout.(*strings.Builder).String()), 64)
-	if err != nil {
-		return 0, fmt.Errorf("cannot parse duration: %w", err)
-	}
-
-	return duration, err
+	return media.Duration.Seconds(), nil
 }
 
 func (g *ScreenGenerator) Generate(req *ScreenshotsRequest) error {
@@ -157,15 +157,17 @@ func (g *ScreenGenerator) Generate(req *ScreenshotsRequest) error {
 		return err
 	}
 
-	filters := []string{
-		"thumbnail=200",
+	filters := &FilterChain{
+		Filters: []*Filter{
+			NewFilter("thumbnail", Arg(200)),
+		},
 	}
 
 	if req.Scale != nil {
-		filters = append(filters, buildScaleArg(req.Scale))
+		filters.Filters = append(filters.Filters, buildScaleFilters(req.Scale)...)
 	}
 
-	filtersStr := strings.Join(filters, ",")
+	filtersStr := filters.String()
 
 	logCtx := context.Background()
 	slogArgs := req.LogArgs
@@ -205,14 +207,15 @@ func (g *ScreenGenerator) Generate(req *ScreenshotsRequest) error {
 				outputFilename,
 			}
 
-			_, err := launchCommand(launchParams{
+			err := g.launchScreenshot(launchParams{
 				ctx:        req.Context,
+				executor:   g.executor,
 				path:       g.ffmpegPath,
 				args:       cmdArgs,
 				needStdout: false,
 				logger:     g.logger,
 				LogArgs:    req.LogArgs,
-			})
+			}, outputFilename)
 			if err != nil {
 				return err
 			}
@@ -244,14 +247,15 @@ func (g *ScreenGenerator) Generate(req *ScreenshotsRequest) error {
 			outputFilename,
 		}
 
-		_, err := launchCommand(launchParams{
+		err := g.launchScreenshot(launchParams{
 			ctx:        req.Context,
+			executor:   g.executor,
 			path:       g.ffmpegPath,
 			args:       cmdArgs,
 			needStdout: false,
 			logger:     g.logger,
 			LogArgs:    req.LogArgs,
-		})
+		}, outputFilename)
 		if err != nil {
 			return err
 		}
@@ -259,3 +263,26 @@ func (g *ScreenGenerator) Generate(req *ScreenshotsRequest) error {
 
 	return nil
 }
+
+// launchScreenshot launches ffmpeg writing a single screenshot into dst, transient failures are retried
+// according to ScreensConfig.RetryPolicy, screenshot written by the cancelled attempt or by the attempt
+// being retried is removed, the screenshot of the previous run is kept
+func (g *ScreenGenerator) launchScreenshot(params launchParams, dst string) error {
+	var attempt int
+
+	return runWithRetry(params.ctx, g.cfg.RetryPolicy, g.logger, params.LogArgs, func() error {
+		attempt++
+		snapshot := newOutputsSnapshot([]string{dst}, nil)
+
+		_, err := launchCommand(params)
+		if err == nil {
+			return nil
+		}
+
+		if _, retry := g.cfg.RetryPolicy.nextBackoff(attempt, err); retry || errors.Is(err, ErrCancelled) {
+			snapshot.removeWritten()
+		}
+
+		return err
+	})
+}
@@ -0,0 +1,331 @@
+// Package ffthumbstest provides a scripted ffthumbs.Executor to test ffthumbs integration without ffmpeg
+package ffthumbstest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codercms/ffthumbs"
+)
+
+// FfmpegVersion is a version reported by the fake "ffmpeg -version" command
+const FfmpegVersion = "6.1.1"
+
+// DefaultFilters are the filters reported by the fake "ffmpeg -filters" command
+var DefaultFilters = []string{
+	"select", "showinfo", "metadata", "scale", "pad", "crop", "tile", "split", "setsar",
+	"eq", "hue", "unsharp", "drawbox", "drawtext", "fps", "thumbnail",
+}
+
+type (
+	// Script describes a scripted process run
+	Script struct {
+		// Stdout is written to the process stdout, e.g. ffprobe JSON output
+		Stdout string
+		// Stderr lines are written to the process stderr, e.g. showinfo lines (see ShowInfoLine)
+		Stderr []string
+		// Progress blocks are written to stdout in the ffmpeg -progress format when "-progress pipe:1" is passed
+		Progress []ffthumbs.Progress
+		// ExitCode is the process exit code
+		ExitCode int
+		// Duration is the process run time after the streams are written, cancellation interrupts it
+		Duration time.Duration
+		// Run is called with the process args on start, e.g. to create output files
+		Run func(args []string)
+	}
+
+	// Matcher tells whether a command should be handled by the script
+	Matcher func(path string, args []string) bool
+
+	// Call is a command launched with the executor
+	Call struct {
+		Path string
+		Args []string
+	}
+
+	// ExitError is returned by Wait of the process exited with non-zero code
+	ExitError struct {
+		Code int
+	}
+
+	// Executor is ffthumbs.Executor replaying scripts of the matched commands without launching processes,
+	// scripts registered later take precedence, commands without a script exit with code 1.
+	// "ffmpeg -version" and "ffmpeg -filters" are always answered by the built-in scripts
+	// (see FfmpegVersion and DefaultFilters).
+	Executor struct {
+		mu       sync.Mutex
+		builtin  []handler
+		handlers []handler
+		calls    []Call
+	}
+
+	handler struct {
+		match  Matcher
+		script func(args []string) Script
+	}
+
+	process struct {
+		ctx    context.Context
+		path   string
+		args   []string
+		script Script
+
+		stdoutW, stderrW *io.PipeWriter
+
+		done       chan struct{}
+		cancelled  chan struct{}
+		cancelOnce sync.Once
+		err        error
+	}
+)
+
+func (e *ExitError) Error() string {
+	if e.Code < 0 {
+		return "signal: killed"
+	}
+
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// NewExecutor returns executor with the built-in scripts only
+func NewExecutor() *Executor {
+	var filters strings.Builder
+	filters.WriteString("Filters:\n")
+
+	for _, filter := range DefaultFilters {
+		fmt.Fprintf(&filters, " ... %-16s V->V       %s filter\n", filter, filter)
+	}
+
+	versionScript := Script{
+		Stdout: "ffmpeg version " + FfmpegVersion + " Copyright (c) 2000-2023 the FFmpeg developers\n",
+	}
+
+	return &Executor{
+		builtin: []handler{
+			{match: MatchAll(MatchCommand("ffmpeg"), MatchArgs("-version")), script: scriptFunc(versionScript)},
+			{match: MatchAll(MatchCommand("ffmpeg"), MatchArgs("-filters")), script: scriptFunc(Script{Stdout: filters.String()})},
+		},
+	}
+}
+
+// MatchCommand matches commands by the binary name, e.g. "ffprobe"
+func MatchCommand(name string) Matcher {
+	return func(p string, _ []string) bool {
+		return path.Base(p) == name
+	}
+}
+
+// MatchArgs matches commands containing all the provided args
+func MatchArgs(args ...string) Matcher {
+	return func(_ string, cmdArgs []string) bool {
+		for _, arg := range args {
+			if !slices.Contains(cmdArgs, arg) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// MatchAll matches commands matched by all the matchers
+func MatchAll(matchers ...Matcher) Matcher {
+	return func(p string, args []string) bool {
+		for _, match := range matchers {
+			if !match(p, args) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Handle replays the script for the matched commands
+func (e *Executor) Handle(match Matcher, script Script) {
+	e.HandleFunc(match, scriptFunc(script))
+}
+
+func scriptFunc(script Script) func([]string) Script {
+	return func([]string) Script {
+		return script
+	}
+}
+
+// HandleFunc replays the script built by the command args for the matched commands
+func (e *Executor) HandleFunc(match Matcher, script func(args []string) Script) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.handlers = append(e.handlers, handler{match: match, script: script})
+}
+
+// Calls returns launched commands in the launch order
+func (e *Executor) Calls() []Call {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return slices.Clone(e.calls)
+}
+
+func (e *Executor) LookPath(file string) (string, error) {
+	return file, nil
+}
+
+func (e *Executor) Command(ctx context.Context, path string, args ...string) ffthumbs.Process {
+	p := &process{
+		ctx:       ctx,
+		path:      path,
+		args:      args,
+		done:      make(chan struct{}),
+		cancelled: make(chan struct{}),
+		script: Script{
+			Stderr:   []string{"ffthumbstest: unexpected command " + path + " " + strings.Join(args, " ")},
+			ExitCode: 1,
+		},
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	handlers := append(slices.Clone(e.handlers), e.builtin...)
+
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if handlers[i].match(path, args) {
+			p.script = handlers[i].script(args)
+			break
+		}
+	}
+
+	e.calls = append(e.calls, Call{Path: path, Args: slices.Clone(args)})
+
+	return p
+}
+
+func (p *process) StdoutPipe() (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	p.stdoutW = w
+
+	return r, nil
+}
+
+func (p *process) StderrPipe() (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	p.stderrW = w
+
+	return r, nil
+}
+
+func (p *process) Start() error {
+	if p.ctx != nil {
+		go func() {
+			select {
+			case <-p.ctx.Done():
+				p.Cancel()
+			case <-p.done:
+			}
+		}()
+	}
+
+	go p.run()
+
+	return nil
+}
+
+func (p *process) run() {
+	defer close(p.done)
+
+	if p.script.Run != nil {
+		p.script.Run(p.args)
+	}
+
+	var wg sync.WaitGroup
+
+	if p.stderrW != nil {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer p.stderrW.Close()
+
+			for _, line := range p.script.Stderr {
+				io.WriteString(p.stderrW, line+"\n")
+			}
+		}()
+	}
+
+	if p.stdoutW != nil {
+		if slices.Contains(p.args, "-progress") {
+			writeProgress(p.stdoutW, p.script.Progress)
+		}
+
+		io.WriteString(p.stdoutW, p.script.Stdout)
+		p.stdoutW.Close()
+	}
+
+	wg.Wait()
+
+	select {
+	case <-time.After(p.script.Duration):
+		if p.script.ExitCode != 0 {
+			p.err = &ExitError{Code: p.script.ExitCode}
+		}
+	case <-p.cancelled:
+		p.err = &ExitError{Code: -1}
+	}
+}
+
+func (p *process) Wait() error {
+	<-p.done
+
+	return p.err
+}
+
+func (p *process) Cancel() error {
+	p.cancelOnce.Do(func() {
+		close(p.cancelled)
+	})
+
+	return nil
+}
+
+func (p *process) String() string {
+	return strings.Join(append([]string{p.path}, p.args...), " ")
+}
+
+// writeProgress writes progress blocks in the ffmpeg -progress format
+func writeProgress(w io.Writer, blocks []ffthumbs.Progress) {
+	for i, progress := range blocks {
+		state := "continue"
+		if i == len(blocks)-1 {
+			state = "end"
+		}
+
+		fmt.Fprintf(w, "frame=%d\nfps=%.2f\nout_time_us=%d\ndup_frames=%d\ndrop_frames=%d\nspeed=%gx\nprogress=%s\n",
+			progress.Frame,
+			progress.FPS,
+			progress.OutTime.Microseconds(),
+			progress.DupFrames,
+			progress.DropFrames,
+			progress.Speed,
+			state,
+		)
+	}
+}
+
+// ShowInfoLine returns the showinfo filter log line reporting frame n of the output with the provided timestamp
+func ShowInfoLine(outputIdx, n int, pts time.Duration) string {
+	return fmt.Sprintf("[showinfo@frames-%d @ 0x5581e1c0] [info] n:%4d pts:%7d pts_time:%g",
+		outputIdx, n, pts.Milliseconds(), pts.Seconds())
+}
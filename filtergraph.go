@@ -0,0 +1,274 @@
+package ffthumbs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// FilterGraph is an ffmpeg filter graph model, it renders to the -filter_complex (or -vf) arg,
+	// see: https://ffmpeg.org/ffmpeg-filters.html#Filtergraph-syntax-1
+	FilterGraph struct {
+		Chains []*FilterChain
+	}
+
+	// FilterChain is a sequence of connected filters with optional labelled input and output pads,
+	// e.g. [in]select=...,scale=320:180[out1][out2]
+	FilterChain struct {
+		// Inputs are the labels of pads connected to the first filter input
+		Inputs []string
+		// Filters is a list of filters applied one by one
+		Filters []*Filter
+		// Outputs are the labels of the last filter output pads
+		Outputs []string
+	}
+
+	// Filter is a single filter with its options, e.g. scale=320:180:force_original_aspect_ratio=decrease
+	Filter struct {
+		// Name is a filter name, e.g. "scale"
+		Name string
+		// Instance is an optional filter instance name, rendered as name@instance
+		Instance string
+		// Options is a list of filter options, positional options should go first
+		Options []FilterOption
+	}
+
+	// FilterOption is a single filter option, Key is empty for the positional options.
+	// Value could be a string, any int or float number, bool, time.Duration (rendered in seconds)
+	// or fmt.Stringer, it is escaped on rendering.
+	FilterOption struct {
+		Key   string
+		Value any
+	}
+)
+
+// NewFilter constructs new Filter
+func NewFilter(name string, options ...FilterOption) *Filter {
+	return &Filter{
+		Name:    name,
+		Options: options,
+	}
+}
+
+// Arg constructs positional FilterOption
+func Arg(value any) FilterOption {
+	return FilterOption{Value: value}
+}
+
+// Opt constructs named FilterOption
+func Opt(key string, value any) FilterOption {
+	return FilterOption{Key: key, Value: value}
+}
+
+// WithInstance sets filter instance name
+func (f *Filter) WithInstance(instance string) *Filter {
+	f.Instance = instance
+	return f
+}
+
+// FullName returns filter name including instance name, e.g. showinfo@frames-0
+func (f *Filter) FullName() string {
+	if len(f.Instance) == 0 {
+		return f.Name
+	}
+
+	return f.Name + "@" + f.Instance
+}
+
+// Option returns named option value
+func (f *Filter) Option(key string) (any, bool) {
+	for _, opt := range f.Options {
+		if opt.Key == key {
+			return opt.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Arg returns positional option value by index
+func (f *Filter) Arg(idx int) (any, bool) {
+	var pos int
+
+	for _, opt := range f.Options {
+		if len(opt.Key) > 0 {
+			continue
+		}
+
+		if pos == idx {
+			return opt.Value, true
+		}
+
+		pos++
+	}
+
+	return nil, false
+}
+
+// String renders filter with escaped options
+func (f *Filter) String() string {
+	var builder strings.Builder
+
+	f.writeTo(&builder)
+
+	return builder.String()
+}
+
+func (f *Filter) writeTo(builder *strings.Builder) {
+	builder.WriteString(f.FullName())
+
+	for idx, opt := range f.Options {
+		if idx == 0 {
+			builder.WriteString("=")
+		} else {
+			builder.WriteString(":")
+		}
+
+		if len(opt.Key) > 0 {
+			builder.WriteString(opt.Key)
+			builder.WriteString("=")
+		}
+
+		builder.WriteString(escapeFilterGraphValue(escapeFilterOptionValue(formatFilterOptionValue(opt.Value))))
+	}
+}
+
+// AddChain appends new chain to the graph and returns it
+func (g *FilterGraph) AddChain(inputs []string, outputs []string, filters ...*Filter) *FilterChain {
+	chain := &FilterChain{
+		Inputs:  inputs,
+		Filters: filters,
+		Outputs: outputs,
+	}
+
+	g.Chains = append(g.Chains, chain)
+
+	return chain
+}
+
+// FindFilter returns the first filter with the provided full name (see Filter.FullName)
+func (g *FilterGraph) FindFilter(fullName string) *Filter {
+	for _, chain := range g.Chains {
+		for _, filter := range chain.Filters {
+			if filter.FullName() == fullName {
+				return filter
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindChainByOutput returns the chain having provided output label
+func (g *FilterGraph) FindChainByOutput(label string) *FilterChain {
+	for _, chain := range g.Chains {
+		for _, out := range chain.Outputs {
+			if out == label {
+				return chain
+			}
+		}
+	}
+
+	return nil
+}
+
+// String renders graph to the -filter_complex arg
+func (g *FilterGraph) String() string {
+	var builder strings.Builder
+
+	for idx, chain := range g.Chains {
+		if idx > 0 {
+			builder.WriteString(";")
+		}
+
+		chain.writeTo(&builder)
+	}
+
+	return builder.String()
+}
+
+// String renders chain, chain without pad labels could be used as -vf arg
+func (c *FilterChain) String() string {
+	var builder strings.Builder
+
+	c.writeTo(&builder)
+
+	return builder.String()
+}
+
+func (c *FilterChain) writeTo(builder *strings.Builder) {
+	writeFilterPadLabels(builder, c.Inputs)
+
+	for idx, filter := range c.Filters {
+		if idx > 0 {
+			builder.WriteString(",")
+		}
+
+		filter.writeTo(builder)
+	}
+
+	writeFilterPadLabels(builder, c.Outputs)
+}
+
+func writeFilterPadLabels(builder *strings.Builder, labels []string) {
+	for _, label := range labels {
+		builder.WriteString("[")
+		builder.WriteString(label)
+		builder.WriteString("]")
+	}
+}
+
+func formatFilterOptionValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+
+		return "0"
+	case time.Duration:
+		return strconv.FormatFloat(v.Truncate(time.Microsecond).Seconds(), 'g', -1, 64)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// escapeFilterOptionValue escapes value on the filter options level
+func escapeFilterOptionValue(value string) string {
+	return escapeChars(value, `\':`)
+}
+
+// escapeFilterGraphValue escapes value on the filter graph description level
+func escapeFilterGraphValue(value string) string {
+	return escapeChars(value, `\'[],;`)
+}
+
+func escapeChars(value string, chars string) string {
+	if !strings.ContainsAny(value, chars) {
+		return value
+	}
+
+	var builder strings.Builder
+
+	for _, r := range value {
+		if strings.ContainsRune(chars, r) {
+			builder.WriteByte('\\')
+		}
+
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,112 @@
+package ffthumbs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilterString(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   string
+	}{
+		{
+			name:   "no options",
+			filter: NewFilter("showinfo"),
+			want:   "showinfo",
+		},
+		{
+			name:   "instance name",
+			filter: NewFilter("showinfo").WithInstance("frames-1"),
+			want:   "showinfo@frames-1",
+		},
+		{
+			name:   "positional and named options",
+			filter: NewFilter("pad", Arg(320), Arg(180), Arg(-1), Arg(-1), Opt("color", "black")),
+			want:   "pad=320:180:-1:-1:color=black",
+		},
+		{
+			name:   "typed values",
+			filter: NewFilter("test", Opt("d", 1500*time.Millisecond), Opt("f", 0.25), Opt("b", true)),
+			want:   "test=d=1.5:f=0.25:b=1",
+		},
+		{
+			name:   "expression escaping",
+			filter: NewFilter("select", Arg("gt(scene,0.4)")),
+			want:   `select=gt(scene\,0.4)`,
+		},
+		{
+			name:   "options level escaping",
+			filter: NewFilter("drawtext", Opt("text", "It's 10:00 [live]; ok")),
+			want:   `drawtext=text=It\\\'s 10\\:00 \[live\]\; ok`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterGraphString(t *testing.T) {
+	graph := &FilterGraph{}
+	graph.AddChain([]string{"0:v"}, []string{"a", "b"}, NewFilter("split", Arg(2)))
+	graph.AddChain([]string{"a"}, []string{"a-out"}, NewFilter("scale", Arg(320), Arg(-1)))
+	graph.AddChain([]string{"b"}, []string{"b-out"}, NewFilter("scale", Arg(-1), Arg(90)), NewFilter("tile", Arg("2x2")))
+
+	want := "[0:v]split=2[a][b];[a]scale=320:-1[a-out];[b]scale=-1:90,tile=2x2[b-out]"
+	if got := graph.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	chain := &FilterChain{Filters: []*Filter{NewFilter("thumbnail", Arg(200)), NewFilter("scale", Arg(320), Arg(180))}}
+	if got := chain.String(); got != "thumbnail=200,scale=320:180" {
+		t.Errorf("unexpected chain without pads: %s", got)
+	}
+}
+
+func TestBuildFilterGraphInspection(t *testing.T) {
+	graph, err := BuildFilterGraph([]*OutputConfig{
+		{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+		{
+			Type:             OutputTypeSprites,
+			SnapshotInterval: 5 * time.Second,
+			Scale:            ScaleConfig{Width: 160, Height: 90},
+			Sprites:          SpritesConfig{Dimensions: SpriteDimensions{Columns: 3, Rows: 2}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tile := graph.FindFilter("tile")
+	if tile == nil {
+		t.Fatal("tile filter not found")
+	}
+
+	if layout, _ := tile.Arg(0); layout != "3x2" {
+		t.Errorf("unexpected tile layout: %v", layout)
+	}
+
+	chain := graph.FindChainByOutput("sprites-1-out")
+	if chain == nil {
+		t.Fatal("sprites output chain not found")
+	}
+
+	scale := chain.Filters[0]
+	if scale.Name != "scale" {
+		t.Fatalf("expected scale filter, got: %s", scale.Name)
+	}
+
+	if width, _ := scale.Arg(0); width != 160 {
+		t.Errorf("unexpected scale width: %v", width)
+	}
+
+	if graph.FindFilter("showinfo@frames-1") == nil {
+		t.Error("showinfo filter of the sprites output not found")
+	}
+}
@@ -0,0 +1,528 @@
+package ffthumbs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codercms/ffthumbs"
+	"github.com/codercms/ffthumbs/ffthumbstest"
+)
+
+func newTestGenerator(t *testing.T, executor *ffthumbstest.Executor, cfg *ffthumbs.Config) *ffthumbs.Generator {
+	t.Helper()
+
+	cfg.Executor = executor
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if cfg.Outputs == nil {
+		cfg.Outputs = []*ffthumbs.OutputConfig{
+			{
+				DstPath:          t.TempDir() + "/%04d.jpg",
+				Scale:            ffthumbs.ScaleConfig{Width: 320, Height: 180},
+				SnapshotInterval: 10 * time.Second,
+				Type:             ffthumbs.OutputTypeThumbs,
+			},
+		}
+	}
+
+	gen, err := ffthumbs.NewGenerator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gen
+}
+
+func TestGenerateScripted(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffprobe"), ffthumbstest.Script{
+		Stdout: `{"streams": [{"index": 0, "codec_type": "video"}], "format": {"duration": "48.000000"}}`,
+	})
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+		Stderr: []string{
+			ffthumbstest.ShowInfoLine(0, 0, 0),
+			ffthumbstest.ShowInfoLine(0, 1, 10*time.Second),
+			ffthumbstest.ShowInfoLine(0, 2, 20*time.Second),
+		},
+		Progress: []ffthumbs.Progress{
+			{Frame: 250, OutTime: 10 * time.Second, Speed: 5},
+			{Frame: 600, OutTime: 24 * time.Second, Speed: 6},
+		},
+	})
+
+	var (
+		mu       sync.Mutex
+		progress []ffthumbs.Progress
+	)
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{
+		OnProgress: func(_ *ffthumbs.GenerateRequest, p ffthumbs.Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			progress = append(progress, p)
+		},
+	})
+
+	res, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "https://cdn.example.com/video.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := res.Outputs[0].Frames
+	if len(frames) != 3 || frames[2].PTS != 20*time.Second {
+		t.Errorf("unexpected frames %+v", frames)
+	}
+
+	if len(progress) != 3 || progress[1].Frame != 600 || !progress[2].Done {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+
+	// Percent is calculated from the probed duration
+	if progress[1].Percent != 50 || progress[1].ETA <= 0 || progress[2].Percent != 100 {
+		t.Errorf("unexpected progress percent %+v", progress)
+	}
+
+	calls := executor.Calls()
+	if last := calls[len(calls)-1]; !slices.Contains(last.Args, "https://cdn.example.com/video.mp4") {
+		t.Errorf("unexpected ffmpeg args %v", last.Args)
+	}
+}
+
+func TestGenerateScriptedProgressProbeFailure(t *testing.T) {
+	for _, probe := range []ffthumbstest.Script{
+		{Stderr: []string{"[error] video.mp4: Invalid data found when processing input"}, ExitCode: 1},
+		{Stdout: `{"streams": [{"index": 0, "codec_type": "audio"}], "format": {"duration": "48.000000"}}`},
+	} {
+		executor := ffthumbstest.NewExecutor()
+		executor.Handle(ffthumbstest.MatchCommand("ffprobe"), probe)
+		executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+			Stderr:   []string{ffthumbstest.ShowInfoLine(0, 0, 0)},
+			Progress: []ffthumbs.Progress{{Frame: 250, OutTime: 10 * time.Second}},
+		})
+
+		var (
+			mu       sync.Mutex
+			progress []ffthumbs.Progress
+		)
+
+		gen := newTestGenerator(t, executor, &ffthumbs.Config{
+			Strategy: ffthumbs.GenerateStrategyDecodeAll,
+			OnProgress: func(_ *ffthumbs.GenerateRequest, p ffthumbs.Progress) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				progress = append(progress, p)
+			},
+		})
+
+		// Media duration is only needed for the progress percent
+		if _, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "video.mp4"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(progress) == 0 || progress[0].Percent != -1 {
+			t.Errorf("unexpected progress %+v", progress)
+		}
+	}
+}
+
+func TestGenerateScriptedKeyframesOnly(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffprobe"), ffthumbstest.Script{
+		Stdout: `{"streams": [{"index": 0, "codec_type": "video"}], "format": {"duration": "30.000000", "start_time": "1.500000"}}`,
+	})
+	executor.Handle(ffthumbstest.MatchAll(ffthumbstest.MatchCommand("ffprobe"), ffthumbstest.MatchArgs("packet=pts_time,flags")), ffthumbstest.Script{
+		Stdout: "1.500000,K__\n1.540000,___\n9.900000,K__\n11.900000,K__\n22.000000,K__\n",
+	})
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+		Stderr: []string{
+			ffthumbstest.ShowInfoLine(0, 0, 0),
+			ffthumbstest.ShowInfoLine(0, 1, 10400*time.Millisecond),
+			ffthumbstest.ShowInfoLine(0, 2, 20500*time.Millisecond),
+		},
+	})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{
+		KeyframesOnly: true,
+		Strategy:      ffthumbs.GenerateStrategyDecodeAll,
+		Outputs: []*ffthumbs.OutputConfig{
+			{
+				DstPath:          t.TempDir() + "/%04d.jpg",
+				Scale:            ffthumbs.ScaleConfig{Width: 320, Height: 180},
+				SnapshotInterval: 10 * time.Second,
+				Type:             ffthumbs.OutputTypeThumbs,
+				KeyframeSnapping: true,
+			},
+		},
+	})
+
+	res, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "video.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if frames := res.Outputs[0].Frames; len(frames) != 3 || frames[1].PTS != 10400*time.Millisecond {
+		t.Errorf("unexpected frames %+v", frames)
+	}
+
+	calls := executor.Calls()
+	args := calls[len(calls)-1].Args
+
+	idx := slices.Index(args, "-filter_complex")
+	if idx < 0 || !slices.Contains(args, "nokey") {
+		t.Fatalf("unexpected ffmpeg args %v", args)
+	}
+
+	// Keyframe at 10.4s (relative to the media start time) is nearer to the 10s boundary than the one at 8.4s
+	if filters := args[idx+1]; !strings.Contains(filters, "lt(abs(t-10.4)") || strings.Contains(filters, "t-8.4") {
+		t.Errorf("unexpected keyframes selection %s", filters)
+	}
+}
+
+func TestGenerateScriptedSegmentsTooShort(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffprobe"), ffthumbstest.Script{
+		Stdout: `{"streams": [{"index": 0, "codec_type": "video"}], "format": {"duration": "8.000000"}}`,
+	})
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+		Stderr: []string{ffthumbstest.ShowInfoLine(0, 0, 0)},
+	})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{Segments: 4, Strategy: ffthumbs.GenerateStrategyDecodeAll})
+
+	if _, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "video.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := executor.Calls()
+	args := calls[len(calls)-1].Args
+
+	// Media shorter than the interval isn't split, the output selection isn't switched to the aligned one
+	idx := slices.Index(args, "-filter_complex")
+	if idx < 0 || !strings.Contains(args[idx+1], "select=bitor(gte(t-prev_selected_t") {
+		t.Errorf("unexpected ffmpeg args %v", args)
+	}
+}
+
+func TestGenerateScriptedRetry(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+
+	var attempt int
+
+	executor.HandleFunc(ffthumbstest.MatchCommand("ffmpeg"), func([]string) ffthumbstest.Script {
+		attempt++
+
+		if attempt == 1 {
+			return ffthumbstest.Script{
+				Stderr:   []string{"[error] https://cdn.example.com/video.mp4: Server returned 502 Bad Gateway"},
+				ExitCode: 1,
+			}
+		}
+
+		return ffthumbstest.Script{Stderr: []string{ffthumbstest.ShowInfoLine(0, 0, 0)}}
+	})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{
+		DisableProgressLogs: true,
+		RetryPolicy:         &ffthumbs.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+
+	res, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "https://cdn.example.com/video.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Attempts != 2 || len(res.AttemptErrors) != 1 || !errors.Is(res.AttemptErrors[0], ffthumbs.ErrHTTPServer) {
+		t.Errorf("unexpected attempts %d: %v", res.Attempts, res.AttemptErrors)
+	}
+
+	var genErr *ffthumbs.GenerateError
+	if errors.As(res.AttemptErrors[0], &genErr); genErr == nil || genErr.ExitCode != 1 || genErr.HTTPStatus != 502 {
+		t.Errorf("unexpected attempt error %#v", genErr)
+	}
+}
+
+func TestGenerateScriptedRetryOutputs(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		stderr string
+		want   []string
+	}{
+		// Files written by the retried attempt are removed, files of the previous run are kept
+		{name: "retried", stderr: "[error] https://cdn.example.com/video.mp4: Server returned 502 Bad Gateway", want: []string{"0002.jpg"}},
+		// Nothing is removed when the request isn't retried
+		{name: "not retried", stderr: "[error] https://cdn.example.com/video.mp4: Server returned 404 Not Found", want: []string{"0001.jpg", "0002.jpg"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// Results of the previous run
+			for _, name := range []string{"0001.jpg", "0002.jpg"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("previous"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			executor := ffthumbstest.NewExecutor()
+
+			var attempt int
+
+			executor.HandleFunc(ffthumbstest.MatchCommand("ffmpeg"), func([]string) ffthumbstest.Script {
+				attempt++
+
+				if attempt > 1 {
+					return ffthumbstest.Script{Stderr: []string{ffthumbstest.ShowInfoLine(0, 0, 0)}}
+				}
+
+				return ffthumbstest.Script{
+					Stderr:   []string{tt.stderr},
+					ExitCode: 1,
+					Run: func([]string) {
+						os.WriteFile(filepath.Join(dir, "0001.jpg"), []byte("jpeg"), 0644)
+					},
+				}
+			})
+
+			gen := newTestGenerator(t, executor, &ffthumbs.Config{
+				DisableProgressLogs: true,
+				RetryPolicy:         &ffthumbs.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+				Outputs: []*ffthumbs.OutputConfig{
+					{
+						DstPath:          filepath.Join(dir, "%04d.jpg"),
+						Scale:            ffthumbs.ScaleConfig{Width: 320, Height: 180},
+						SnapshotInterval: 10 * time.Second,
+						Type:             ffthumbs.OutputTypeThumbs,
+					},
+				},
+			})
+
+			gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "https://cdn.example.com/video.mp4"})
+
+			entries, _ := os.ReadDir(dir)
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("unexpected outputs left: %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateScriptedCancel(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{Duration: time.Hour})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{DisableProgressLogs: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "video.mp4", Context: ctx})
+
+	if !errors.Is(err, ffthumbs.ErrCancelled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestGenerateScriptedCancelOutputs(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		keep bool
+		// timeout is the request timeout, the request is cancelled before ffmpeg is launched when it is 0
+		timeout time.Duration
+		want    []string
+	}{
+		{
+			name:    "remove",
+			timeout: 20 * time.Millisecond,
+			want:    []string{"0002.jpg", "0003.jpg", "manifest.json", "thumbnails.vtt"},
+		},
+		{
+			name:    "keep",
+			keep:    true,
+			timeout: 20 * time.Millisecond,
+			want:    []string{"0001.jpg", "0002.jpg", "0003.jpg", "manifest.json", "sprite-0001.jpg", "thumbnails.vtt"},
+		},
+		{
+			name: "cancelled before launch",
+			want: []string{"0001.jpg", "0002.jpg", "0003.jpg", "manifest.json", "sprite-0001.jpg", "thumbnails.vtt"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// Results of the previous run
+			for _, name := range []string{"0001.jpg", "0002.jpg", "0003.jpg", "sprite-0001.jpg", "thumbnails.vtt", "manifest.json"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("previous"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			executor := ffthumbstest.NewExecutor()
+			executor.Handle(ffthumbstest.MatchCommand("ffprobe"), ffthumbstest.Script{
+				Stdout: `{"streams": [{"index": 0, "codec_type": "video"}], "format": {"duration": "48.000000"}}`,
+			})
+			executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+				Duration: time.Hour,
+				Run: func([]string) {
+					// The interrupted attempt rewrote the first image and the sprite
+					for _, name := range []string{"0001.jpg", "sprite-0001.jpg"} {
+						os.WriteFile(filepath.Join(dir, name), []byte("jpeg"), 0644)
+					}
+				},
+			})
+
+			gen := newTestGenerator(t, executor, &ffthumbs.Config{
+				DisableProgressLogs:  true,
+				KeepCancelledOutputs: tt.keep,
+				Outputs: []*ffthumbs.OutputConfig{
+					{
+						DstPath:          filepath.Join(dir, "%04d.jpg"),
+						Scale:            ffthumbs.ScaleConfig{Width: 320, Height: 180},
+						SnapshotInterval: 10 * time.Second,
+						Type:             ffthumbs.OutputTypeThumbs,
+						Manifest:         &ffthumbs.ManifestConfig{},
+					},
+					{
+						DstPath:          filepath.Join(dir, "sprite-%04d.jpg"),
+						Scale:            ffthumbs.ScaleConfig{Width: 160, Height: 90},
+						SnapshotInterval: 10 * time.Second,
+						Type:             ffthumbs.OutputTypeSprites,
+						Sprites:          ffthumbs.SpritesConfig{Dimensions: ffthumbs.SpriteDimensions{Columns: 5, Rows: 5}},
+						VTT:              &ffthumbs.VTTConfig{},
+					},
+				},
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			if _, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "video.mp4", Context: ctx}); !errors.Is(err, ffthumbs.ErrCancelled) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			entries, _ := os.ReadDir(dir)
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("unexpected outputs left: %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratorShutdown(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+		Stderr:   []string{ffthumbstest.ShowInfoLine(0, 0, 0)},
+		Duration: 50 * time.Millisecond,
+	})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{DisableProgressLogs: true})
+
+	done := make(chan *ffthumbs.GenerateResult, 1)
+	if _, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "video.mp4", DoneChan: done}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := gen.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error %v", err)
+	}
+
+	if res := <-done; res.Err != nil {
+		t.Errorf("in-flight request wasn't drained: %v", res.Err)
+	}
+
+	if _, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "video.mp4"}); !errors.Is(err, ffthumbs.ErrGeneratorClosed) {
+		t.Errorf("unexpected async error %v", err)
+	}
+
+	if _, err := gen.Generate(&ffthumbs.GenerateRequest{MediaURL: "video.mp4"}); !errors.Is(err, ffthumbs.ErrGeneratorClosed) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestGeneratorShutdownDrained(t *testing.T) {
+	gen := newTestGenerator(t, ffthumbstest.NewExecutor(), &ffthumbs.Config{DisableProgressLogs: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nothing is cancelled, so done ctx doesn't fail the shutdown
+	if err := gen.Shutdown(ctx); err != nil {
+		t.Errorf("unexpected shutdown error %v", err)
+	}
+
+	if err := gen.Close(); err != nil {
+		t.Errorf("unexpected close error %v", err)
+	}
+}
+
+func TestGeneratorClose(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{Duration: time.Hour})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{DisableProgressLogs: true})
+
+	done := make(chan *ffthumbs.GenerateResult, 1)
+	if _, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "video.mp4", DoneChan: done}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gen.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected close error %v", err)
+	}
+
+	if res := <-done; !errors.Is(res.Err, ffthumbs.ErrGeneratorClosed) {
+		t.Errorf("unexpected request error %v", res.Err)
+	}
+}
+
+func TestGeneratorShutdownDeadline(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{Duration: time.Hour})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{DisableProgressLogs: true})
+
+	done := make(chan *ffthumbs.GenerateResult, 1)
+	if _, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "video.mp4", DoneChan: done}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := gen.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected shutdown error %v", err)
+	}
+
+	res := <-done
+	if !errors.Is(res.Err, ffthumbs.ErrCancelled) || !errors.Is(res.Err, ffthumbs.ErrGeneratorClosed) {
+		t.Errorf("unexpected request error %v", res.Err)
+	}
+
+	if err := gen.Close(); err != nil {
+		t.Errorf("unexpected close error %v", err)
+	}
+}
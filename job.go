@@ -0,0 +1,268 @@
+package ffthumbs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrJobCancelled is a cancellation cause of the requests cancelled with Job.Cancel
+var ErrJobCancelled = errors.New("job cancelled")
+
+// JobStatus is a status of the async request
+type JobStatus int
+
+const (
+	// JobStatusQueued - the request waits for a free worker
+	JobStatusQueued JobStatus = iota
+	// JobStatusRunning - the request is being processed
+	JobStatusRunning
+	// JobStatusSucceeded - the request is processed successfully
+	JobStatusSucceeded
+	// JobStatusFailed - the request processing failed
+	JobStatusFailed
+	// JobStatusCancelled - the request is cancelled (see ErrCancelled)
+	JobStatusCancelled
+)
+
+var jobStatusNames = [...]string{
+	JobStatusQueued:    "queued",
+	JobStatusRunning:   "running",
+	JobStatusSucceeded: "succeeded",
+	JobStatusFailed:    "failed",
+	JobStatusCancelled: "cancelled",
+}
+
+func (s JobStatus) String() string {
+	if s < 0 || int(s) >= len(jobStatusNames) {
+		return "unknown"
+	}
+
+	return jobStatusNames[s]
+}
+
+// Job is a handle of the async request returned by GenerateAsync, it is safe for concurrent use.
+// Duplicate requests share the job (see Config.EnableDedup), so Cancel affects all their callers.
+type Job struct {
+	id  uint64
+	req *GenerateRequest
+	gen *Generator
+	// key is the deduplication key, attached are the duplicate requests, both are guarded by Generator.jobsMu
+	key      string
+	attached []*GenerateRequest
+
+	mu       sync.Mutex
+	status   JobStatus
+	progress Progress
+	result   *GenerateResult
+	// cancel cancels the running request, cancelRequested is set when the job is cancelled before it is started
+	cancel          context.CancelCauseFunc
+	cancelRequested bool
+
+	done chan struct{}
+}
+
+// ID returns the request id, the same as GenerateRequest.GetId()
+func (j *Job) ID() uint64 {
+	return j.id
+}
+
+// Request returns the request the job was created for, duplicate requests attached to the job aren't processed
+func (j *Job) Request() *GenerateRequest {
+	return j.req
+}
+
+// Status returns current job status
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status
+}
+
+// Progress returns the last progress update of the request
+func (j *Job) Progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.progress
+}
+
+// Result returns the request result, nil is returned until the job is done
+func (j *Job) Result() *GenerateResult {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.result
+}
+
+// Done returns a channel closed when the job is done
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait waits until the job is done and returns its result, result's Err is the same as returned error.
+// When ctx is done earlier, nil result and ctx error are returned.
+func (j *Job) Wait(ctx context.Context) (*GenerateResult, error) {
+	select {
+	case <-j.done:
+		res := j.Result()
+
+		return res, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Cancel cancels the job: a queued request is removed from the queue, a running request is cancelled
+// the same way as with GenerateRequest.Context. The cancelled job result error matches ErrCancelled
+// and ErrJobCancelled. Cancel of the done job does nothing.
+func (j *Job) Cancel() {
+	j.mu.Lock()
+
+	switch j.status {
+	case JobStatusRunning:
+		j.mu.Unlock()
+		j.cancel(ErrJobCancelled)
+
+		return
+	case JobStatusQueued:
+		// The request could be taken by a worker at the moment, it is cancelled on start then
+		j.cancelRequested = true
+		j.mu.Unlock()
+	default:
+		j.mu.Unlock()
+
+		return
+	}
+
+	if !j.gen.queue.remove(j.req) {
+		return
+	}
+
+	res := &GenerateResult{
+		Req: j.req,
+		Err: &GenerateError{Kind: ErrorKindCancelled, ExitCode: -1, Err: ErrJobCancelled},
+	}
+
+	attached := j.gen.finishJob(j, res)
+
+	// DoneChan receiver could be busy, the caller isn't blocked
+	go j.gen.deliverResult(j.req, res, attached)
+}
+
+// start marks the job running, cancel is used to cancel the request processing
+func (j *Job) start(cancel context.CancelCauseFunc) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status = JobStatusRunning
+	j.cancel = cancel
+
+	if j.cancelRequested {
+		cancel(ErrJobCancelled)
+	}
+}
+
+func (j *Job) setProgress(progress Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.progress = progress
+}
+
+// finish stores the job result, false is returned when the job is already done
+func (j *Job) finish(res *GenerateResult) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.result != nil {
+		return false
+	}
+
+	j.result = res
+
+	switch {
+	case res.Err == nil:
+		j.status = JobStatusSucceeded
+	case errors.Is(res.Err, ErrCancelled):
+		j.status = JobStatusCancelled
+	default:
+		j.status = JobStatusFailed
+	}
+
+	close(j.done)
+
+	return true
+}
+
+// Job returns the queued or running job by its id
+func (g *Generator) Job(id uint64) (*Job, bool) {
+	g.jobsMu.Lock()
+	defer g.jobsMu.Unlock()
+
+	job, ok := g.jobs[id]
+
+	return job, ok
+}
+
+// newJob creates and registers the job of the async request, when the in-flight job has the same deduplication key
+// the request is attached to it and the in-flight job is returned with attached flag
+func (g *Generator) newJob(req *GenerateRequest) (job *Job, attached bool) {
+	key := g.dedupKey(req)
+
+	g.jobsMu.Lock()
+	defer g.jobsMu.Unlock()
+
+	if inflight, ok := g.inflight[key]; ok && key != "" {
+		req.id = inflight.id
+
+		if req.DoneChan != nil {
+			inflight.attached = append(inflight.attached, req)
+		}
+
+		return inflight, true
+	}
+
+	req.id = g.lastReqId.Add(1)
+
+	job = &Job{
+		id:   req.id,
+		req:  req,
+		gen:  g,
+		key:  key,
+		done: make(chan struct{}),
+	}
+
+	req.job = job
+	g.jobs[job.id] = job
+
+	if key != "" {
+		g.inflight[key] = job
+	}
+
+	return job, false
+}
+
+// finishJob unregisters and completes the job, the attached requests are returned
+// unless the job is already done
+func (g *Generator) finishJob(job *Job, res *GenerateResult) []*GenerateRequest {
+	g.jobsMu.Lock()
+
+	delete(g.jobs, job.id)
+
+	if g.inflight[job.key] == job {
+		delete(g.inflight, job.key)
+	}
+
+	attached := job.attached
+	job.attached = nil
+
+	g.jobsMu.Unlock()
+
+	if !job.finish(res) {
+		return nil
+	}
+
+	return attached
+}
@@ -0,0 +1,105 @@
+package ffthumbs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/codercms/ffthumbs"
+	"github.com/codercms/ffthumbs/ffthumbstest"
+)
+
+// waitJobStatus waits until the job gets the status
+func waitJobStatus(t *testing.T, job *ffthumbs.Job, status ffthumbs.JobStatus) {
+	t.Helper()
+
+	for i := 0; i < 500 && job.Status() != status; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if job.Status() != status {
+		t.Fatalf("job status is %s, want %s", job.Status(), status)
+	}
+}
+
+func TestJob(t *testing.T) {
+	gen, release := newBlockedGenerator(t, &ffthumbs.Config{Concurrency: 1, QueueSize: 1})
+
+	running, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "first.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan *ffthumbs.GenerateResult, 1)
+
+	queued, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "second.mp4", DoneChan: done})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitJobStatus(t, running, ffthumbs.JobStatusRunning)
+
+	if queued.Status() != ffthumbs.JobStatusQueued || queued.ID() != queued.Request().GetId() {
+		t.Errorf("unexpected queued job %d status %s", queued.ID(), queued.Status())
+	}
+
+	if job, ok := gen.Job(queued.ID()); !ok || job != queued {
+		t.Errorf("queued job %d isn't found", queued.ID())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if res, err := running.Wait(ctx); res != nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected wait result %v, %v", res, err)
+	}
+
+	queued.Cancel()
+
+	if queued.Status() != ffthumbs.JobStatusCancelled || gen.QueueDepth() != 0 {
+		t.Errorf("queued job wasn't cancelled, status %s, queue depth %d", queued.Status(), gen.QueueDepth())
+	}
+
+	if res := <-done; !errors.Is(res.Err, ffthumbs.ErrJobCancelled) || !errors.Is(res.Err, ffthumbs.ErrCancelled) {
+		t.Errorf("unexpected cancelled job error %v", res.Err)
+	}
+
+	close(release)
+
+	res, err := running.Wait(context.Background())
+	if err != nil || res != running.Result() {
+		t.Fatalf("unexpected job result %v, %v", res, err)
+	}
+
+	if running.Status() != ffthumbs.JobStatusSucceeded || !running.Progress().Done {
+		t.Errorf("unexpected job status %s and progress %+v", running.Status(), running.Progress())
+	}
+
+	if _, ok := gen.Job(running.ID()); ok {
+		t.Errorf("done job %d is still registered", running.ID())
+	}
+}
+
+func TestJobCancelRunning(t *testing.T) {
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{Duration: time.Hour})
+
+	gen := newTestGenerator(t, executor, &ffthumbs.Config{DisableProgressLogs: true})
+
+	job, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "video.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitJobStatus(t, job, ffthumbs.JobStatusRunning)
+	job.Cancel()
+
+	if _, err := job.Wait(context.Background()); !errors.Is(err, ffthumbs.ErrJobCancelled) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if job.Status() != ffthumbs.JobStatusCancelled {
+		t.Errorf("unexpected job status %s", job.Status())
+	}
+}
@@ -0,0 +1,251 @@
+package ffthumbs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	// showInfoPattern matches showinfo filter log line, e.g.
+	// [showinfo@frames-0 @ 0x5581e1c0] [info] n:   3 pts:  92092 pts_time:3.06973 ...
+	showInfoPattern = regexp.MustCompile(`\[(?:showinfo@)?frames-(\d+) @ [^\]]+\] (?:\[info\] )?n:\s*(\d+)\s+pts:\s*(-?\d+)\s+pts_time:(-?[0-9.e+-]+)`)
+
+	// sceneScorePattern matches scene score line of the metadata filter, e.g.
+	// [metadata@scene-0 @ 0x5581e1c0] [info] lavfi.scene_score=0.523410
+	sceneScorePattern = regexp.MustCompile(`\[(?:metadata@)?scene-(\d+) @ [^\]]+\] (?:\[info\] )?lavfi\.scene_score=([0-9.e+-]+)`)
+)
+
+type (
+	// FrameInfo describes a single frame written to an output
+	FrameInfo struct {
+		// File is a path to the file containing the frame
+		File string
+		// PTS is a frame presentation timestamp
+		PTS time.Duration
+		// Index is a frame sequence number within the output (starting from 0)
+		Index int
+		// Tile is a frame position in the sprite, only set for OutputTypeSprites
+		Tile *TilePosition
+		// SceneScore is a scene change score of the frame, only set for the scene-change selection mode
+		SceneScore float64
+	}
+
+	// TilePosition describes where the frame is placed in the sprite
+	TilePosition struct {
+		Column int `json:"column"`
+		Row    int `json:"row"`
+		X      int `json:"x"`
+		Y      int `json:"y"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+
+	// OutputResult describes files written to the output
+	OutputResult struct {
+		// Idx is an index of OutputConfig in Config.Outputs
+		Idx int `json:"output"`
+		// Frames is a list of frames written to the output in the emitting order
+		Frames []FrameInfo `json:"frames"`
+	}
+
+	// ManifestConfig is a JSON frames manifest configuration
+	ManifestConfig struct {
+		// DstPath sets manifest output path, default: output dir + DefaultManifestFilename
+		// can be overridden in GenerateRequest.ManifestDst
+		DstPath string
+	}
+
+	frameInfoJSON struct {
+		File    string        `json:"file"`
+		PTSTime float64       `json:"pts_time"`
+		Index   int           `json:"index"`
+		Tile    *TilePosition `json:"tile,omitempty"`
+		Scene   float64       `json:"scene_score,omitempty"`
+	}
+
+	// capturedFrame is a frame reported by the showinfo filter
+	capturedFrame struct {
+		outputIdx int
+		n         int
+		pts       time.Duration
+	}
+
+	// framesCollector collects frames info reported by filters to the ffmpeg log
+	framesCollector struct {
+		frames []capturedFrame
+		// sceneScores are scene scores of output frames in the emitting order, map format is an output index => scores
+		sceneScores map[int][]float64
+	}
+)
+
+// parseLine parses ffmpeg log line, it returns false when line doesn't contain frame info
+func (c *framesCollector) parseLine(line string) bool {
+	if frame, ok := parseShowInfoLine(line); ok {
+		c.frames = append(c.frames, frame)
+		return true
+	}
+
+	if outputIdx, score, ok := parseSceneScoreLine(line); ok {
+		if c.sceneScores == nil {
+			c.sceneScores = map[int][]float64{}
+		}
+
+		c.sceneScores[outputIdx] = append(c.sceneScores[outputIdx], score)
+
+		return true
+	}
+
+	return false
+}
+
+func (f FrameInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(frameInfoJSON{
+		File:    f.File,
+		PTSTime: f.PTS.Seconds(),
+		Index:   f.Index,
+		Tile:    f.Tile,
+		Scene:   f.SceneScore,
+	})
+}
+
+func (f *FrameInfo) UnmarshalJSON(data []byte) error {
+	var raw frameInfoJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	f.File = raw.File
+	f.PTS = durationFromSeconds(raw.PTSTime)
+	f.Index = raw.Index
+	f.Tile = raw.Tile
+	f.SceneScore = raw.Scene
+
+	return nil
+}
+
+// parseShowInfoLine parses showinfo filter log line, ok is false when line isn't a showinfo frame line
+func parseShowInfoLine(line string) (frame capturedFrame, ok bool) {
+	match := showInfoPattern.FindStringSubmatch(line)
+	if len(match) < 5 {
+		return frame, false
+	}
+
+	outputIdx, err := strconv.Atoi(match[1])
+	if err != nil {
+		return frame, false
+	}
+
+	n, err := strconv.Atoi(match[2])
+	if err != nil {
+		return frame, false
+	}
+
+	ptsTime, err := strconv.ParseFloat(match[4], 64)
+	if err != nil {
+		return frame, false
+	}
+
+	return capturedFrame{
+		outputIdx: outputIdx,
+		n:         n,
+		pts:       durationFromSeconds(ptsTime),
+	}, true
+}
+
+// parseSceneScoreLine parses metadata filter scene score log line
+func parseSceneScoreLine(line string) (outputIdx int, score float64, ok bool) {
+	match := sceneScorePattern.FindStringSubmatch(line)
+	if len(match) < 3 {
+		return 0, 0, false
+	}
+
+	outputIdx, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	score, err = strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return outputIdx, score, true
+}
+
+// buildOutputResult maps captured frames of the output to the written files,
+// sceneScores are assigned to the frames in the emitting order
+func buildOutputResult(output *OutputConfig, outputDst string, frames []capturedFrame, sceneScores []float64) (*OutputResult, error) {
+	res := &OutputResult{
+		Idx:    output.idx,
+		Frames: make([]FrameInfo, 0, len(frames)),
+	}
+
+	if len(frames) == 0 {
+		return res, nil
+	}
+
+	var tileWidth, tileHeight, tilesPerSprite int
+
+	if output.Type == OutputTypeSprites {
+		var err error
+
+		tileWidth, tileHeight, err = resolveTileSize(output, outputDst)
+		if err != nil {
+			return nil, err
+		}
+
+		tilesPerSprite = output.Sprites.Dimensions.Columns * output.Sprites.Dimensions.Rows
+	}
+
+	for i, frame := range frames {
+		info := FrameInfo{
+			PTS:   frame.pts,
+			Index: frame.n,
+		}
+
+		if i < len(sceneScores) {
+			info.SceneScore = sceneScores[i]
+		}
+
+		switch output.Type {
+		case OutputTypeThumbs:
+			info.File = formatOutputFilename(outputDst, frame.n+1)
+		case OutputTypeSprites:
+			tileIdx := frame.n % tilesPerSprite
+			column := tileIdx % output.Sprites.Dimensions.Columns
+			row := tileIdx / output.Sprites.Dimensions.Columns
+
+			info.File = formatOutputFilename(outputDst, frame.n/tilesPerSprite+1)
+			info.Tile = &TilePosition{
+				Column: column,
+				Row:    row,
+				X:      column * tileWidth,
+				Y:      row * tileHeight,
+				Width:  tileWidth,
+				Height: tileHeight,
+			}
+		}
+
+		res.Frames = append(res.Frames, info)
+	}
+
+	return res, nil
+}
+
+// writeManifestFile writes output result as JSON into the file located at dst
+func writeManifestFile(dst string, res *OutputResult) error {
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode manifest: %w", err)
+	}
+
+	if err := os.WriteFile(dst, data, 0644); err != nil {
+		return fmt.Errorf("cannot write manifest file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package ffthumbs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFramesCollector(t *testing.T) {
+	lines := []string{
+		"[showinfo@frames-1 @ 0x5581e1c0] [info] n:   0 pts:      0 pts_time:0       duration:   1001 duration_time:0.0333667",
+		"[showinfo@frames-1 @ 0x5581e1c0] [info] config in time_base: 1/30000, frame_rate: 30000/1001",
+		"[metadata@scene-1 @ 0x5581e2c0] [info] frame:0    pts:0       pts_time:0",
+		"[metadata@scene-1 @ 0x5581e2c0] [info] lavfi.scene_score=0.000000",
+		"[showinfo@frames-1 @ 0x5581e1c0] [info] n:   1 pts: 123123 pts_time:4.1041  duration:   1001 duration_time:0.0333667",
+		"[metadata@scene-1 @ 0x5581e2c0] [info] frame:1    pts:123123  pts_time:4.1041",
+		"[metadata@scene-1 @ 0x5581e2c0] [info] lavfi.scene_score=0.523410",
+		"[in#0/mov,mp4,m4a,3gp,3g2,mj2 @ 0x5581e000] [error] Error during demuxing: Invalid data found when processing input",
+	}
+
+	var collector framesCollector
+	var skipped int
+
+	for _, line := range lines {
+		if !collector.parseLine(line) {
+			skipped++
+		}
+	}
+
+	if skipped != 4 {
+		t.Errorf("expected 4 lines without frame info, got %d", skipped)
+	}
+
+	output := &OutputConfig{idx: 1, Type: OutputTypeThumbs}
+
+	res, err := buildOutputResult(output, "thumbs/%04d.jpg", collector.frames, collector.sceneScores[1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(res.Frames))
+	}
+
+	frame := res.Frames[1]
+
+	if frame.File != "thumbs/0002.jpg" || frame.Index != 1 || frame.PTS != 4104100*time.Microsecond || frame.SceneScore != 0.52341 {
+		t.Errorf("unexpected frame: %+v", frame)
+	}
+}
+
+func TestBuildOutputResultSprites(t *testing.T) {
+	output := &OutputConfig{
+		Type:    OutputTypeSprites,
+		Scale:   ScaleConfig{Width: 160, Height: 90},
+		Sprites: SpritesConfig{Dimensions: SpriteDimensions{Columns: 3, Rows: 2}},
+	}
+
+	var frames []capturedFrame
+	for n := 0; n < 8; n++ {
+		frames = append(frames, capturedFrame{n: n, pts: time.Duration(n) * 5 * time.Second})
+	}
+
+	res, err := buildOutputResult(output, "sprites/%04d.jpg", frames, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The last partial sprite
+	last := res.Frames[7]
+
+	if last.File != "sprites/0002.jpg" {
+		t.Errorf("unexpected sprite file: %s", last.File)
+	}
+
+	if *last.Tile != (TilePosition{Column: 1, Row: 0, X: 160, Y: 0, Width: 160, Height: 90}) {
+		t.Errorf("unexpected tile position: %+v", *last.Tile)
+	}
+
+	cues := buildSpriteVTTCues(&spriteVTTParams{
+		VTTDir:   "sprites",
+		Interval: 5 * time.Second,
+		Duration: 38 * time.Second,
+	}, res.Frames)
+
+	if len(cues) != 8 {
+		t.Fatalf("expected 8 cues, got %d", len(cues))
+	}
+
+	if cues[7].Start != 35*time.Second || cues[7].End != 38*time.Second || cues[7].URL != "0002.jpg#xywh=160,0,160,90" {
+		t.Errorf("unexpected last cue: %+v", cues[7])
+	}
+}
+
+func TestParseShowInfoLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   capturedFrame
+		wantOk bool
+	}{
+		{
+			name:   "ffmpeg 6 with log level",
+			line:   "[showinfo@frames-2 @ 0x5581e1c0] [info] n:  12 pts: 369369 pts_time:12.3123 duration:   1001",
+			want:   capturedFrame{outputIdx: 2, n: 12, pts: 12312300 * time.Microsecond},
+			wantOk: true,
+		},
+		{
+			name:   "without filter name and log level",
+			line:   "[frames-0 @ 0x5581e1c0] n:   0 pts:      0 pts_time:0       pos:  48 fmt:yuv420p",
+			want:   capturedFrame{outputIdx: 0, n: 0, pts: 0},
+			wantOk: true,
+		},
+		{
+			name:   "negative pts",
+			line:   "[showinfo@frames-1 @ 0x5581e1c0] [info] n:   0 pts:  -1001 pts_time:-0.0333667",
+			want:   capturedFrame{outputIdx: 1, n: 0, pts: -33366700 * time.Nanosecond},
+			wantOk: true,
+		},
+		{
+			name:   "exponent pts_time",
+			line:   "[showinfo@frames-0 @ 0x5581e1c0] [info] n:   1 pts:      1 pts_time:1e-05",
+			want:   capturedFrame{outputIdx: 0, n: 1, pts: 10 * time.Microsecond},
+			wantOk: true,
+		},
+		{
+			name: "stream config line",
+			line: "[showinfo@frames-0 @ 0x5581e1c0] [info] config in time_base: 1/30000, frame_rate: 30000/1001",
+		},
+		{
+			name: "other filter",
+			line: "[metadata@scene-0 @ 0x5581e2c0] [info] frame:1    pts:123123  pts_time:4.1041",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, ok := parseShowInfoLine(tt.line)
+
+			if ok != tt.wantOk || frame != tt.want {
+				t.Errorf("got %+v, %t, want %+v, %t", frame, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestWriteManifestFile(t *testing.T) {
+	res := &OutputResult{
+		Idx: 1,
+		Frames: []FrameInfo{
+			{
+				File:  "sprites/0001.jpg",
+				PTS:   2500 * time.Millisecond,
+				Index: 0,
+				Tile:  &TilePosition{Column: 0, Row: 0, X: 0, Y: 0, Width: 160, Height: 90},
+			},
+			{
+				File:       "sprites/0001.jpg",
+				PTS:        7500 * time.Millisecond,
+				Index:      1,
+				Tile:       &TilePosition{Column: 1, Row: 0, X: 160, Y: 0, Width: 160, Height: 90},
+				SceneScore: 0.5,
+			},
+		},
+	}
+
+	dst := filepath.Join(t.TempDir(), "manifest.json")
+
+	if err := writeManifestFile(dst, res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		Output int `json:"output"`
+		Frames []map[string]any
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("cannot decode manifest: %v", err)
+	}
+
+	if raw.Output != 1 || raw.Frames[0]["pts_time"] != 2.5 || raw.Frames[0]["scene_score"] != nil || raw.Frames[1]["scene_score"] != 0.5 {
+		t.Errorf("unexpected manifest:\n%s", data)
+	}
+
+	var decoded OutputResult
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("cannot decode manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(&decoded, res) {
+		t.Errorf("decoded manifest %+v differs from %+v", decoded, *res)
+	}
+}
@@ -0,0 +1,419 @@
+package ffthumbs
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// TimeRange is a media time range
+type TimeRange struct {
+	Start time.Duration
+	// End is an exclusive range end
+	End time.Duration
+}
+
+// requestPlan is a request resolved against the generator config
+type requestPlan struct {
+	// ctx is the request context, it is also cancelled when the generator shutdown deadline exceeds
+	ctx context.Context
+	// outputs are the request outputs, generator config outputs are used as is when there is nothing to resolve
+	outputs []*OutputConfig
+	// filtersStr is the request -filter_complex arg
+	filtersStr string
+	// media is a probed media info, nil when media wasn't probed
+	media *MediaInfo
+	// duration is a probed media duration, zero when media wasn't probed
+	duration time.Duration
+	// start is the processing window start (see GenerateRequest.Start)
+	start time.Duration
+	// end is the processing window end, 0 - until the end of the media (see GenerateRequest.End)
+	end time.Duration
+	// skip are the sorted excluded ranges within the processing window (see GenerateRequest.Skip)
+	skip []TimeRange
+	// graphOpts are the request filter graph options
+	graphOpts *graphOptions
+	// strategy is the resolved request generate strategy
+	strategy GenerateStrategy
+	// framesGroups are the select groups which frames are extracted beforehand,
+	// only set for GenerateStrategySeek and segmented processing
+	framesGroups []*framesGroup
+	// segments are the time segments processed in parallel relative to the window start,
+	// only set for segmented processing, the last segment End is 0
+	segments []TimeRange
+	// progress is the request progress tracker, nil when progress is neither logged nor reported
+	progress *progressTracker
+}
+
+// ResolveCountInterval returns snapshot interval which evenly spreads count snapshots over the media duration
+func ResolveCountInterval(count int, duration time.Duration) time.Duration {
+	if count <= 0 {
+		return 0
+	}
+
+	return (duration / time.Duration(count)).Truncate(time.Microsecond)
+}
+
+// planRequest probes media when needed and resolves outputs, filters and strategy of the request
+func (g *Generator) planRequest(ctx context.Context, req *GenerateRequest, slogArgs []slog.Attr) (*requestPlan, error) {
+	if err := validateRequestRanges(req); err != nil {
+		return nil, err
+	}
+
+	plan := &requestPlan{
+		ctx:        ctx,
+		outputs:    g.cfg.Outputs,
+		filtersStr: g.cfg.filtersStr,
+		start:      req.Start,
+		end:        req.End,
+		graphOpts:  g.cfg.graphOptions(),
+		strategy:   GenerateStrategyDecodeAll,
+	}
+
+	probeRequired := g.probeRequired || req.Strategy == GenerateStrategySeek
+
+	if len(g.ffprobePath) > 0 && (probeRequired || g.cfg.OnProgress != nil || req.OnProgress != nil) {
+		media, err := g.probe(ctx, req.MediaURL, slogArgs)
+		if err == nil && media.VideoStream() == nil {
+			err = &GenerateError{Kind: ErrorKindNoVideoStream, ExitCode: -1}
+		}
+
+		switch {
+		case err == nil:
+			plan.media = media
+			plan.duration = media.Duration
+
+			// Window end past the media end is ignored
+			if plan.duration > 0 && plan.end >= plan.duration {
+				plan.end = 0
+			}
+		case probeRequired || errors.Is(err, ErrCancelled):
+			return nil, err
+		default:
+			// Media duration is only used for the progress percent
+			args := slogArgs
+			args = append(args,
+				slog.String("err", err.Error()),
+			)
+
+			g.logger.LogAttrs(context.Background(), slog.LevelWarn, "media probing failed, progress percent is unknown", args...)
+		}
+	}
+
+	plan.skip = clipTimeRanges(req.Skip, plan.start, plan.windowEnd())
+
+	var err error
+
+	if g.cfg.dynamicOutputs {
+		plan.outputs, err = resolveOutputs(g.cfg.Outputs, plan.windowDuration())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	strategy, err := g.resolveStrategy(req, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []TimeRange
+
+	if strategy == GenerateStrategyDecodeAll && g.cfg.Segments > 1 {
+		segments = planSegments(planFramesGroups(plan.outputs, plan.graphOpts), plan.windowDuration(), g.cfg.Segments)
+	}
+
+	extracted := strategy == GenerateStrategySeek || len(segments) > 1
+	rebuildGraph := g.cfg.dynamicOutputs || g.cfg.KeyframesOnly || extracted || len(plan.skip) > 0
+
+	if !rebuildGraph {
+		return plan, nil
+	}
+
+	// Outputs are copied since the graph building mutates them
+	if !g.cfg.dynamicOutputs {
+		plan.outputs, err = resolveOutputs(g.cfg.Outputs, plan.windowDuration())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if g.cfg.KeyframesOnly {
+		plan.graphOpts.keyframes, err = g.probeKeyframes(ctx, req, plan, slogArgs)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.graphOpts.windowDuration = plan.windowDuration()
+	}
+
+	if extracted {
+		plan.strategy = strategy
+		plan.graphOpts.extractedInputs = true
+		plan.framesGroups = planFramesGroups(plan.outputs, plan.graphOpts)
+
+		if strategy == GenerateStrategySeek {
+			for _, group := range plan.framesGroups {
+				group.points = plan.planSeekPoints(&group.selection)
+			}
+		} else {
+			plan.segments = segments
+		}
+	} else {
+		plan.graphOpts.skipRanges = shiftTimeRanges(plan.skip, plan.start)
+	}
+
+	graph, err := buildFilterGraph(plan.outputs, plan.graphOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.filtersStr = graph.String()
+
+	return plan, nil
+}
+
+// probeKeyframes returns keyframes timestamps of the media video stream relative to the processing window start,
+// keyframes outside the window and inside the skipped ranges are dropped
+func (g *Generator) probeKeyframes(ctx context.Context, req *GenerateRequest, plan *requestPlan, slogArgs []slog.Attr) ([]time.Duration, error) {
+	// ffprobe reads packets of the absolute timestamps, while the window is relative to the media start time
+	startTime := plan.media.StartTime
+	end := plan.windowEnd()
+
+	interval := TimeRange{Start: startTime + plan.start}
+	if end > 0 {
+		interval.End = startTime + end
+	}
+
+	keyframes, err := probeKeyframes(launchParams{
+		ctx:      ctx,
+		executor: g.executor,
+		path:     g.ffprobePath,
+		args:     g.probeArgs,
+		logger:   g.logger,
+		LogArgs:  slogArgs,
+	}, req.MediaURL, plan.media.VideoStream().Index, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	windowKeyframes := make([]time.Duration, 0, len(keyframes))
+
+	for _, keyframe := range keyframes {
+		keyframe -= startTime
+
+		if keyframe+keyframeTolerance < plan.start || (end > 0 && keyframe >= end) || isSkipped(plan.skip, keyframe) {
+			continue
+		}
+
+		windowKeyframes = append(windowKeyframes, keyframe-plan.start)
+	}
+
+	return windowKeyframes, nil
+}
+
+// isSkipped tells whether the timestamp is inside one of the ranges
+func isSkipped(ranges []TimeRange, ts time.Duration) bool {
+	for _, r := range ranges {
+		if ts >= r.Start && ts < r.End {
+			return true
+		}
+	}
+
+	return false
+}
+
+// windowEnd returns the processing window end, 0 when it is unknown
+func (p *requestPlan) windowEnd() time.Duration {
+	if p.end > 0 {
+		return p.end
+	}
+
+	return p.duration
+}
+
+// windowDuration returns the processing window duration, 0 when it is unknown
+func (p *requestPlan) windowDuration() time.Duration {
+	end := p.windowEnd()
+	if end <= p.start {
+		return 0
+	}
+
+	return end - p.start
+}
+
+// planSeekPoints returns seek points of the selection within the processing window,
+// points inside the skipped ranges are moved to the range end
+func (p *requestPlan) planSeekPoints(selection *frameSelection) []time.Duration {
+	points := planSeekPoints(selection.interval, selection.limit, p.windowDuration())
+
+	if selection.snapToKeyframes {
+		points = selectNearestKeyframes(p.graphOpts.keyframes, selection, p.graphOpts.windowDuration)
+
+		// Keyframes are sought slightly before, so the rounded probed timestamps don't skip them
+		for i := range points {
+			points[i] = max(points[i]-keyframeTolerance, 0)
+		}
+	}
+
+	planned := make([]time.Duration, 0, len(points))
+
+	for _, point := range points {
+		point += p.start
+
+		for _, skip := range p.skip {
+			if point >= skip.Start && point < skip.End {
+				point = skip.End
+			}
+		}
+
+		if point >= p.windowEnd() || (len(planned) > 0 && point <= planned[len(planned)-1]) {
+			continue
+		}
+
+		planned = append(planned, point)
+	}
+
+	return planned
+}
+
+// inputWindowArgs returns ffmpeg input args limiting input to the window range relative to the processing window start,
+// zero end means the processing window end
+func (p *requestPlan) inputWindowArgs(window TimeRange) []string {
+	var args []string
+
+	if start := p.start + window.Start; start > 0 {
+		args = append(args, "-ss", formatTimeArg(start))
+	}
+
+	if window.End > 0 {
+		args = append(args, "-to", formatTimeArg(p.start+window.End))
+	} else if p.end > 0 {
+		args = append(args, "-to", formatTimeArg(p.end))
+	}
+
+	return args
+}
+
+// formatTimeArg formats the time as ffmpeg CLI seconds value, unlike filter values large times
+// aren't formatted in the exponent notation
+func formatTimeArg(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// clipTimeRanges returns sorted ranges clipped to the [start, end) window, zero end means no limit
+func clipTimeRanges(ranges []TimeRange, start, end time.Duration) []TimeRange {
+	clipped := make([]TimeRange, 0, len(ranges))
+
+	for _, r := range ranges {
+		r.Start = max(r.Start, start)
+		if end > 0 {
+			r.End = min(r.End, end)
+		}
+
+		if r.End > r.Start {
+			clipped = append(clipped, r)
+		}
+	}
+
+	slices.SortFunc(clipped, func(a, b TimeRange) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	return clipped
+}
+
+// shiftTimeRanges returns ranges shifted back by offset, ranges before offset are dropped
+func shiftTimeRanges(ranges []TimeRange, offset time.Duration) []TimeRange {
+	shifted := make([]TimeRange, 0, len(ranges))
+
+	for _, r := range ranges {
+		if r.End <= offset {
+			continue
+		}
+
+		shifted = append(shifted, TimeRange{
+			Start: max(r.Start-offset, 0),
+			End:   r.End - offset,
+		})
+	}
+
+	return shifted
+}
+
+// resolveStrategy returns the request generate strategy, GenerateStrategyAuto is resolved
+// against the probed duration and resolved outputs
+func (g *Generator) resolveStrategy(req *GenerateRequest, plan *requestPlan) (GenerateStrategy, error) {
+	strategy := req.Strategy
+	if strategy == GenerateStrategyAuto {
+		strategy = g.cfg.Strategy
+	}
+
+	switch strategy {
+	case GenerateStrategyAuto:
+		if plan.windowDuration() > 0 && isSeekable(plan.outputs, g.cfg.getSeekMinInterval(), true) {
+			return GenerateStrategySeek, nil
+		}
+
+		return GenerateStrategyDecodeAll, nil
+	case GenerateStrategySeek:
+		if err := validateStrategy(strategy, plan.outputs); err != nil {
+			return 0, err
+		}
+
+		if plan.windowDuration() <= 0 {
+			return 0, fmt.Errorf("seek strategy requires media duration, but media wasn't probed")
+		}
+
+		return strategy, nil
+	default:
+		return strategy, validateStrategy(strategy, plan.outputs)
+	}
+}
+
+// isAligned tells whether output frames are selected per interval range,
+// segmented processing always selects frames per interval range
+func (p *requestPlan) isAligned(output *OutputConfig) bool {
+	if output.Scene != nil || p.graphOpts.keyframesOnly {
+		return false
+	}
+
+	return output.ExactAlignment || len(p.segments) > 0
+}
+
+// isTimestampBased tells whether output frames should be described by their timestamps
+// rather than by the snapshot interval, i.e. frames aren't placed exactly on the interval boundaries
+func (p *requestPlan) isTimestampBased(output *OutputConfig) bool {
+	return output.Scene != nil || p.graphOpts.keyframesOnly || p.start > 0 || len(p.skip) > 0
+}
+
+// resolveOutputs returns outputs copies with snapshot intervals resolved for the media of provided duration
+func resolveOutputs(outputs []*OutputConfig, duration time.Duration) ([]*OutputConfig, error) {
+	resolved := make([]*OutputConfig, 0, len(outputs))
+
+	for idx, output := range outputs {
+		outputCopy := *output
+
+		if output.Count > 0 {
+			if duration <= 0 {
+				return nil, fmt.Errorf("output %d is in count mode, but media duration is unknown", idx)
+			}
+
+			outputCopy.SnapshotInterval = ResolveCountInterval(output.Count, duration)
+		} else if output.IntervalRules != nil {
+			if duration <= 0 {
+				return nil, fmt.Errorf("output %d uses interval rules, but media duration is unknown", idx)
+			}
+
+			outputCopy.SnapshotInterval = output.IntervalRules.Resolve(duration)
+		}
+
+		resolved = append(resolved, &outputCopy)
+	}
+
+	return resolved, nil
+}
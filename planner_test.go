@@ -0,0 +1,161 @@
+package ffthumbs
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResolveOutputsCount(t *testing.T) {
+	outputs := []*OutputConfig{
+		{Type: OutputTypeThumbs, SnapshotInterval: 5 * time.Second},
+		{
+			Type:    OutputTypeSprites,
+			Count:   64,
+			Sprites: SpritesConfig{Dimensions: SpriteDimensions{Columns: 8, Rows: 8}},
+		},
+	}
+
+	resolved, err := resolveOutputs(outputs, 100*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resolved[0].SnapshotInterval != 5*time.Second {
+		t.Errorf("interval output changed: %s", resolved[0].SnapshotInterval)
+	}
+
+	if want := 1562500 * time.Microsecond; resolved[1].SnapshotInterval != want {
+		t.Errorf("got interval %s, want %s", resolved[1].SnapshotInterval, want)
+	}
+
+	if outputs[1].SnapshotInterval != 0 {
+		t.Error("config output must not be modified")
+	}
+
+	if _, err := resolveOutputs(outputs, 0); err == nil {
+		t.Error("expected error for unknown duration")
+	}
+}
+
+func TestIntervalRulesResolve(t *testing.T) {
+	rules := &IntervalRules{
+		Rules: []IntervalRule{
+			{MaxDuration: 2 * time.Minute, Interval: time.Second},
+			{MaxDuration: 30 * time.Minute, Interval: 5 * time.Second},
+			{Interval: 10 * time.Second},
+		},
+		MaxFrames: 600,
+	}
+
+	tests := []struct {
+		duration time.Duration
+		want     time.Duration
+	}{
+		{duration: 30 * time.Second, want: time.Second},
+		{duration: 2 * time.Minute, want: 5 * time.Second},
+		{duration: 20 * time.Minute, want: 5 * time.Second},
+		{duration: 90 * time.Minute, want: 10 * time.Second},
+		{duration: 3 * time.Hour, want: 18 * time.Second},
+		{duration: 3*time.Hour + time.Second, want: 18001667 * time.Microsecond},
+	}
+
+	for _, tt := range tests {
+		if got := rules.Resolve(tt.duration); got != tt.want {
+			t.Errorf("duration %s: got interval %s, want %s", tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestRequestPlanWindow(t *testing.T) {
+	plan := &requestPlan{
+		duration:  10 * time.Minute,
+		start:     time.Minute,
+		end:       5 * time.Minute,
+		graphOpts: &graphOptions{},
+	}
+
+	plan.skip = clipTimeRanges([]TimeRange{
+		{Start: 4 * time.Minute, End: 6 * time.Minute},
+		{Start: 30 * time.Second, End: 90 * time.Second},
+		{Start: 7 * time.Minute, End: 8 * time.Minute},
+	}, plan.start, plan.windowEnd())
+
+	wantSkip := []TimeRange{
+		{Start: time.Minute, End: 90 * time.Second},
+		{Start: 4 * time.Minute, End: 5 * time.Minute},
+	}
+
+	if !slices.Equal(plan.skip, wantSkip) {
+		t.Errorf("got skip ranges %v, want %v", plan.skip, wantSkip)
+	}
+
+	if got := plan.windowDuration(); got != 4*time.Minute {
+		t.Errorf("got window duration %s, want 4m", got)
+	}
+
+	points := plan.planSeekPoints(&frameSelection{interval: time.Minute})
+	wantPoints := []time.Duration{90 * time.Second, 2 * time.Minute, 3 * time.Minute}
+
+	if !slices.Equal(points, wantPoints) {
+		t.Errorf("got seek points %v, want %v", points, wantPoints)
+	}
+
+	if got := plan.inputWindowArgs(TimeRange{Start: time.Minute, End: 2 * time.Minute}); !slices.Equal(got, []string{"-ss", "120", "-to", "180"}) {
+		t.Errorf("unexpected segment input args: %v", got)
+	}
+
+	if got := plan.inputWindowArgs(TimeRange{}); !slices.Equal(got, []string{"-ss", "60", "-to", "300"}) {
+		t.Errorf("unexpected window input args: %v", got)
+	}
+
+	// Long media times aren't formatted in the exponent notation
+	longPlan := &requestPlan{start: 1234567*time.Second + 250*time.Millisecond, end: 2000000 * time.Second}
+	if got := longPlan.inputWindowArgs(TimeRange{}); !slices.Equal(got, []string{"-ss", "1234567.25", "-to", "2000000"}) {
+		t.Errorf("unexpected long window input args: %v", got)
+	}
+
+	graph, err := buildFilterGraph([]*OutputConfig{
+		{Type: OutputTypeThumbs, SnapshotInterval: 10 * time.Second, Scale: ScaleConfig{Width: 320, Height: 180}},
+	}, &graphOptions{skipRanges: shiftTimeRanges(plan.skip, plan.start)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantGraph := `[0:v]select@skip=bitor(lt(t\,0)\,gte(t\,30))*bitor(lt(t\,180)\,gte(t\,240)),` +
+		`select=bitor(gte(t-prev_selected_t\,10)\,isnan(prev_selected_t)),scale=320:180,showinfo@frames-0[thumbs-0-out]`
+
+	if got := graph.String(); got != wantGraph {
+		t.Errorf("filters mismatch\ngot:  %s\nwant: %s", got, wantGraph)
+	}
+}
+
+func TestValidateRequestRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *GenerateRequest
+		wantErr bool
+	}{
+		{name: "no ranges", req: &GenerateRequest{}},
+		{name: "window", req: &GenerateRequest{Start: time.Second, End: time.Minute, Skip: []TimeRange{{Start: 0, End: time.Second}}}},
+		{name: "negative start", req: &GenerateRequest{Start: -time.Second}, wantErr: true},
+		{name: "end before start", req: &GenerateRequest{Start: time.Minute, End: time.Second}, wantErr: true},
+		{name: "empty skip range", req: &GenerateRequest{Skip: []TimeRange{{Start: time.Second, End: time.Second}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRequestRanges(tt.req)
+
+			var validationErr *ValidationError
+			if tt.wantErr && (!errors.As(err, &validationErr) || validationErr.Type != ValidationErrTypeTimeRange) {
+				t.Errorf("expected time range ValidationError, got: %v", err)
+			}
+
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
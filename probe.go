@@ -0,0 +1,390 @@
+package ffthumbs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// MediaInfo describes media probed by ffprobe
+	MediaInfo struct {
+		// FormatName is a container format name, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+		FormatName string
+		// Duration is a media duration, 0 when unknown (e.g. live streams)
+		Duration time.Duration
+		// StartTime is a media start time (start_time)
+		StartTime time.Duration
+		// BitRate is a media bit rate in bits per second, 0 when unknown
+		BitRate  int64
+		Streams  []StreamInfo
+		Chapters []ChapterInfo
+	}
+
+	// StreamInfo describes a single media stream
+	StreamInfo struct {
+		Index int
+		// Type is a stream codec type, e.g. "video", "audio", "subtitle"
+		Type      string
+		CodecName string
+		Profile   string
+		Width     int
+		Height    int
+		// SAR is a sample aspect ratio, zero when undefined
+		SAR Rational
+		// DAR is a display aspect ratio, zero when undefined
+		DAR Rational
+		// Rotation is a display rotation in degrees as reported by ffprobe, e.g. -90 for portrait phone videos
+		Rotation float64
+		// FrameRate is a stream real base frame rate (r_frame_rate)
+		FrameRate Rational
+		// AvgFrameRate is a stream average frame rate (avg_frame_rate)
+		AvgFrameRate Rational
+		// FieldOrder is a video field order, e.g. "progressive", "tt"
+		FieldOrder    string
+		PixFmt        string
+		ColorTransfer string
+		ColorSpace    string
+		StartTime     time.Duration
+		Duration      time.Duration
+		// AttachedPic tells that the stream is a cover art rather than a video
+		AttachedPic bool
+		Tags        map[string]string
+	}
+
+	// ChapterInfo describes a single media chapter
+	ChapterInfo struct {
+		ID    int64
+		Start time.Duration
+		End   time.Duration
+		Title string
+	}
+
+	// Rational is a rational number, e.g. frame rate 30000/1001 or aspect ratio 16:9
+	Rational struct {
+		Num int
+		Den int
+	}
+
+	probeOutputJSON struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			StartTime  string `json:"start_time"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			Index         int               `json:"index"`
+			CodecType     string            `json:"codec_type"`
+			CodecName     string            `json:"codec_name"`
+			Profile       string            `json:"profile"`
+			Width         int               `json:"width"`
+			Height        int               `json:"height"`
+			SAR           string            `json:"sample_aspect_ratio"`
+			DAR           string            `json:"display_aspect_ratio"`
+			RFrameRate    string            `json:"r_frame_rate"`
+			AvgFrameRate  string            `json:"avg_frame_rate"`
+			FieldOrder    string            `json:"field_order"`
+			PixFmt        string            `json:"pix_fmt"`
+			ColorTransfer string            `json:"color_transfer"`
+			ColorSpace    string            `json:"color_space"`
+			StartTime     string            `json:"start_time"`
+			Duration      string            `json:"duration"`
+			Disposition   map[string]int    `json:"disposition"`
+			Tags          map[string]string `json:"tags"`
+			SideDataList  []struct {
+				SideDataType string  `json:"side_data_type"`
+				Rotation     float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Chapters []struct {
+			ID        int64             `json:"id"`
+			StartTime string            `json:"start_time"`
+			EndTime   string            `json:"end_time"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"chapters"`
+	}
+)
+
+// Float64 returns rational value, 0 when denominator is zero
+func (r Rational) Float64() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+
+	return float64(r.Num) / float64(r.Den)
+}
+
+// IsZero tells whether rational is undefined
+func (r Rational) IsZero() bool {
+	return r.Num == 0 || r.Den == 0
+}
+
+func (r Rational) String() string {
+	return strconv.Itoa(r.Num) + "/" + strconv.Itoa(r.Den)
+}
+
+// VideoStream returns the first video stream which isn't a cover art, nil when there is no video stream
+func (m *MediaInfo) VideoStream() *StreamInfo {
+	for i := range m.Streams {
+		if m.Streams[i].Type == "video" && !m.Streams[i].AttachedPic {
+			return &m.Streams[i]
+		}
+	}
+
+	return nil
+}
+
+// DisplaySize returns video frame size respecting sample aspect ratio and rotation
+func (s *StreamInfo) DisplaySize() (width, height int) {
+	width, height = s.Width, s.Height
+
+	if !s.SAR.IsZero() && s.SAR.Num != s.SAR.Den {
+		width = int(math.Round(float64(width) * s.SAR.Float64()))
+	}
+
+	if rotation := math.Mod(math.Abs(s.Rotation), 180); rotation == 90 {
+		width, height = height, width
+	}
+
+	return width, height
+}
+
+// probeMedia returns media info using ffprobe
+func probeMedia(params launchParams, mediaURL string) (*MediaInfo, error) {
+	params.args = append(slices.Clone(params.args),
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		"-of", "json",
+		mediaURL,
+	)
+	params.needStdout = true
+
+	stdout, err := launchCommand(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMediaInfo([]byte(stdout))
+}
+
+// probeKeyframes returns sorted keyframes timestamps of the stream using ffprobe packets listing,
+// only packets within the interval are read, zero interval end means the media end
+func probeKeyframes(params launchParams, mediaURL string, streamIdx int, interval TimeRange) ([]time.Duration, error) {
+	params.args = append(slices.Clone(params.args),
+		"-v", "error",
+		"-select_streams", strconv.Itoa(streamIdx),
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=print_section=0",
+	)
+
+	if interval.Start > 0 || interval.End > 0 {
+		readInterval := formatTimeArg(interval.Start) + "%"
+		if interval.End > 0 {
+			readInterval += formatTimeArg(interval.End)
+		}
+
+		params.args = append(params.args, "-read_intervals", readInterval)
+	}
+
+	params.args = append(params.args, mediaURL)
+	params.needStdout = true
+
+	stdout, err := launchCommand(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKeyframes(stdout), nil
+}
+
+// parseKeyframes parses ffprobe "pts_time,flags" CSV lines, packets without timestamp are skipped
+func parseKeyframes(data string) []time.Duration {
+	var keyframes []time.Duration
+
+	for _, line := range strings.Split(data, "\n") {
+		ptsStr, flags, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if !ok || !strings.HasPrefix(flags, "K") {
+			continue
+		}
+
+		pts, err := strconv.ParseFloat(ptsStr, 64)
+		if err != nil {
+			continue
+		}
+
+		keyframes = append(keyframes, durationFromSeconds(pts))
+	}
+
+	slices.Sort(keyframes)
+
+	return keyframes
+}
+
+// parseMediaInfo parses ffprobe JSON output
+func parseMediaInfo(data []byte) (*MediaInfo, error) {
+	var raw probeOutputJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("cannot parse ffprobe output: %w", err)
+	}
+
+	info := &MediaInfo{
+		FormatName: raw.Format.FormatName,
+		Duration:   parseProbeDuration(raw.Format.Duration),
+		StartTime:  parseProbeDuration(raw.Format.StartTime),
+		Streams:    make([]StreamInfo, 0, len(raw.Streams)),
+		Chapters:   make([]ChapterInfo, 0, len(raw.Chapters)),
+	}
+
+	info.BitRate, _ = strconv.ParseInt(raw.Format.BitRate, 10, 64)
+
+	for _, rawStream := range raw.Streams {
+		stream := StreamInfo{
+			Index:         rawStream.Index,
+			Type:          rawStream.CodecType,
+			CodecName:     rawStream.CodecName,
+			Profile:       rawStream.Profile,
+			Width:         rawStream.Width,
+			Height:        rawStream.Height,
+			SAR:           parseRational(rawStream.SAR),
+			DAR:           parseRational(rawStream.DAR),
+			FrameRate:     parseRational(rawStream.RFrameRate),
+			AvgFrameRate:  parseRational(rawStream.AvgFrameRate),
+			FieldOrder:    rawStream.FieldOrder,
+			PixFmt:        rawStream.PixFmt,
+			ColorTransfer: rawStream.ColorTransfer,
+			ColorSpace:    rawStream.ColorSpace,
+			StartTime:     parseProbeDuration(rawStream.StartTime),
+			Duration:      parseProbeDuration(rawStream.Duration),
+			AttachedPic:   rawStream.Disposition["attached_pic"] == 1,
+			Tags:          rawStream.Tags,
+		}
+
+		// Rotation is reported in the display matrix side data, older ffprobe versions report "rotate" tag
+		for _, sideData := range rawStream.SideDataList {
+			if sideData.SideDataType == "Display Matrix" {
+				stream.Rotation = sideData.Rotation
+			}
+		}
+
+		if rotate, ok := rawStream.Tags["rotate"]; ok && stream.Rotation == 0 {
+			stream.Rotation, _ = strconv.ParseFloat(rotate, 64)
+		}
+
+		info.Streams = append(info.Streams, stream)
+	}
+
+	for _, rawChapter := range raw.Chapters {
+		info.Chapters = append(info.Chapters, ChapterInfo{
+			ID:    rawChapter.ID,
+			Start: parseProbeDuration(rawChapter.StartTime),
+			End:   parseProbeDuration(rawChapter.EndTime),
+			Title: rawChapter.Tags["title"],
+		})
+	}
+
+	// Some containers don't report format duration
+	if info.Duration <= 0 {
+		if video := info.VideoStream(); video != nil {
+			info.Duration = video.Duration
+		}
+	}
+
+	return info, nil
+}
+
+// parseProbeDuration parses ffprobe seconds value, 0 is returned for "N/A" and empty values
+func parseProbeDuration(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+
+	return durationFromSeconds(seconds)
+}
+
+// parseRational parses "num/den" or "num:den" value, zero Rational is returned on failure
+func parseRational(value string) Rational {
+	numStr, denStr, ok := strings.Cut(value, "/")
+	if !ok {
+		numStr, denStr, ok = strings.Cut(value, ":")
+	}
+
+	if !ok {
+		return Rational{}
+	}
+
+	num, err := strconv.Atoi(numStr)
+	if err != nil {
+		return Rational{}
+	}
+
+	den, err := strconv.Atoi(denStr)
+	if err != nil {
+		return Rational{}
+	}
+
+	return Rational{Num: num, Den: den}
+}
+
+// Probe returns media info using ffprobe, Config.Headers are passed to ffprobe
+func (g *Generator) Probe(ctx context.Context, mediaURL string) (*MediaInfo, error) {
+	return g.probe(ctx, mediaURL, nil)
+}
+
+func (g *Generator) probe(ctx context.Context, mediaURL string, slogArgs []slog.Attr) (*MediaInfo, error) {
+	ffprobePath := g.ffprobePath
+
+	// ffprobe is only looked up on the generator creation when config requires it
+	if len(ffprobePath) == 0 {
+		var err error
+
+		ffprobePath, err = getVerifiedFfprobePath(g.executor, g.cfg.FfprobePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return probeMedia(launchParams{
+		ctx:      ctx,
+		executor: g.executor,
+		path:     ffprobePath,
+		args:     g.probeArgs,
+		logger:   g.logger,
+		LogArgs:  slogArgs,
+	}, mediaURL)
+}
+
+// Probe returns media info using ffprobe, ScreensConfig.Headers are passed to ffprobe
+func (g *ScreenGenerator) Probe(ctx context.Context, mediaURL string) (*MediaInfo, error) {
+	return g.probe(ctx, mediaURL, nil)
+}
+
+func (g *ScreenGenerator) probe(ctx context.Context, mediaURL string, slogArgs []slog.Attr) (*MediaInfo, error) {
+	var media *MediaInfo
+
+	err := runWithRetry(ctx, g.cfg.RetryPolicy, g.logger, slogArgs, func() error {
+		var err error
+
+		media, err = probeMedia(launchParams{
+			ctx:      ctx,
+			executor: g.executor,
+			path:     g.ffprobePath,
+			args:     g.probeArgs,
+			logger:   g.logger,
+			LogArgs:  slogArgs,
+		}, mediaURL)
+
+		return err
+	})
+
+	return media, err
+}
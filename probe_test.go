@@ -0,0 +1,130 @@
+package ffthumbs
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+const testProbeOutput = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "mjpeg",
+            "codec_type": "video",
+            "width": 600,
+            "height": 600,
+            "disposition": {"default": 0, "attached_pic": 1}
+        },
+        {
+            "index": 1,
+            "codec_name": "h264",
+            "profile": "High",
+            "codec_type": "video",
+            "width": 1440,
+            "height": 1080,
+            "sample_aspect_ratio": "4:3",
+            "display_aspect_ratio": "16:9",
+            "pix_fmt": "yuv420p",
+            "field_order": "tt",
+            "color_space": "bt709",
+            "color_transfer": "bt709",
+            "r_frame_rate": "30000/1001",
+            "avg_frame_rate": "30000/1001",
+            "start_time": "1.400000",
+            "duration": "3600.033367",
+            "disposition": {"default": 1, "attached_pic": 0},
+            "tags": {"language": "und"},
+            "side_data_list": [
+                {"side_data_type": "Display Matrix", "displaymatrix": "...", "rotation": -90}
+            ]
+        },
+        {
+            "index": 2,
+            "codec_name": "aac",
+            "codec_type": "audio",
+            "r_frame_rate": "0/0",
+            "start_time": "1.400000",
+            "duration": "3600.000000"
+        }
+    ],
+    "chapters": [
+        {"id": 0, "time_base": "1/1000", "start_time": "0.000000", "end_time": "90.500000", "tags": {"title": "Intro"}},
+        {"id": 1, "time_base": "1/1000", "start_time": "90.500000", "end_time": "3600.000000", "tags": {"title": "Main"}}
+    ],
+    "format": {
+        "format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+        "start_time": "1.400000",
+        "duration": "3601.433367",
+        "bit_rate": "5000000"
+    }
+}`
+
+func TestParseMediaInfo(t *testing.T) {
+	info, err := parseMediaInfo([]byte(testProbeOutput))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Duration.Round(time.Microsecond) != 3601433367*time.Microsecond || info.StartTime != 1400*time.Millisecond {
+		t.Errorf("unexpected duration %s or start time %s", info.Duration, info.StartTime)
+	}
+
+	if info.BitRate != 5000000 || len(info.Streams) != 3 {
+		t.Errorf("unexpected format info: %+v", info)
+	}
+
+	video := info.VideoStream()
+	if video == nil || video.Index != 1 {
+		t.Fatalf("cover art must be skipped, got: %+v", video)
+	}
+
+	if video.FrameRate != (Rational{Num: 30000, Den: 1001}) || video.SAR != (Rational{Num: 4, Den: 3}) || video.DAR != (Rational{Num: 16, Den: 9}) {
+		t.Errorf("unexpected rationals: %s, %s, %s", video.FrameRate, video.SAR, video.DAR)
+	}
+
+	if video.Rotation != -90 || video.FieldOrder != "tt" || video.ColorTransfer != "bt709" {
+		t.Errorf("unexpected video stream: %+v", video)
+	}
+
+	if width, height := video.DisplaySize(); width != 1080 || height != 1920 {
+		t.Errorf("unexpected display size: %dx%d", width, height)
+	}
+
+	if !info.Streams[2].FrameRate.IsZero() {
+		t.Errorf("audio frame rate must be zero, got %s", info.Streams[2].FrameRate)
+	}
+
+	if len(info.Chapters) != 2 || info.Chapters[1].Title != "Main" || info.Chapters[1].Start != 90500*time.Millisecond {
+		t.Errorf("unexpected chapters: %+v", info.Chapters)
+	}
+
+	if _, err := parseMediaInfo([]byte("not json")); err == nil {
+		t.Error("expected error for invalid output")
+	}
+}
+
+func TestParseMediaInfoStreamDuration(t *testing.T) {
+	info, err := parseMediaInfo([]byte(`{"streams": [{"index": 0, "codec_type": "video", "duration": "12.5"}], "format": {"duration": "N/A"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Duration != 12500*time.Millisecond {
+		t.Errorf("expected video stream duration, got %s", info.Duration)
+	}
+}
+
+func TestParseKeyframes(t *testing.T) {
+	data := "12.012000,K__\n10.010000,K_\n11.011000,___\nN/A,K__\n\n13.013000,KD_\n"
+
+	want := []time.Duration{
+		durationFromSeconds(10.01),
+		durationFromSeconds(12.012),
+		durationFromSeconds(13.013),
+	}
+
+	if got := parseKeyframes(data); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+package ffthumbs
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Progress is a request processing progress, values of parallel ffmpeg processes
+	// (see Config.Segments) are summed up
+	Progress struct {
+		// Frame is a number of processed frames
+		Frame int64
+		// FPS is a processing speed in frames per second
+		FPS float64
+		// OutTime is a processed media duration
+		OutTime time.Duration
+		// Speed is a processing speed relative to the media playback speed, e.g. 8 is 8x faster than realtime
+		Speed float64
+		// DupFrames is a number of duplicated frames
+		DupFrames int64
+		// DropFrames is a number of dropped frames
+		DropFrames int64
+		// Percent is a completion percentage (0-100), -1 when media duration is unknown
+		Percent float64
+		// Elapsed is a time passed since processing start
+		Elapsed time.Duration
+		// ETA is an estimated time to completion, 0 when unknown
+		ETA time.Duration
+		// Done is set for the last progress update of the successfully processed request
+		Done bool
+	}
+
+	// ProgressFunc receives request progress updates, it is called synchronously from the processing goroutines
+	// one call at a time, so it must not block for long
+	ProgressFunc func(req *GenerateRequest, progress Progress)
+
+	// progressTracker combines progress of the request ffmpeg processes
+	progressTracker struct {
+		mu sync.Mutex
+
+		gen        *Generator
+		req        *GenerateRequest
+		onProgress ProgressFunc
+		slogArgs   []slog.Attr
+
+		start time.Time
+		// duration is a processed media duration, 0 when unknown
+		duration time.Duration
+		// parts are the last progress updates of each ffmpeg process
+		parts []Progress
+		// totalPoints and donePoints are the seek points counters of GenerateStrategySeek
+		totalPoints int
+		donePoints  int
+	}
+)
+
+// newProgressTracker returns nil when progress is neither logged nor reported nor tracked by the job
+func (g *Generator) newProgressTracker(req *GenerateRequest, plan *requestPlan, slogArgs []slog.Attr) *progressTracker {
+	onProgress := req.OnProgress
+	if onProgress == nil {
+		onProgress = g.cfg.OnProgress
+	}
+
+	if onProgress == nil && g.cfg.DisableProgressLogs && req.job == nil {
+		return nil
+	}
+
+	tracker := &progressTracker{
+		gen:        g,
+		req:        req,
+		onProgress: onProgress,
+		slogArgs:   slogArgs,
+		start:      time.Now(),
+		duration:   plan.windowDuration(),
+		parts:      make([]Progress, max(len(plan.segments), 1)),
+	}
+
+	for _, group := range plan.framesGroups {
+		tracker.totalPoints += len(group.points)
+	}
+
+	return tracker
+}
+
+// partListener returns ffmpeg progress listener of the part (e.g. segment), nil tracker returns nil listener
+func (t *progressTracker) partListener(part int) func(Progress) {
+	if t == nil {
+		return nil
+	}
+
+	return func(progress Progress) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.parts[part] = progress
+		t.notify(false)
+	}
+}
+
+// pointDone counts extracted seek point
+func (t *progressTracker) pointDone() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.donePoints++
+	t.notify(false)
+}
+
+// finish reports request completion
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.notify(true)
+}
+
+func (t *progressTracker) notify(done bool) {
+	var progress Progress
+
+	for _, part := range t.parts {
+		progress.Frame += part.Frame
+		progress.FPS += part.FPS
+		progress.OutTime += part.OutTime
+		progress.Speed += part.Speed
+		progress.DupFrames += part.DupFrames
+		progress.DropFrames += part.DropFrames
+	}
+
+	progress.Elapsed = time.Since(t.start)
+	progress.Done = done
+
+	switch {
+	case done:
+		progress.Percent = 100
+	case t.totalPoints > 0:
+		progress.Percent = float64(t.donePoints) / float64(t.totalPoints) * 100
+	case t.duration > 0:
+		progress.Percent = min(float64(progress.OutTime)/float64(t.duration)*100, 100)
+	default:
+		progress.Percent = -1
+	}
+
+	if progress.Percent > 0 && progress.Percent < 100 {
+		progress.ETA = time.Duration(float64(progress.Elapsed) * (100 - progress.Percent) / progress.Percent)
+	}
+
+	if !t.gen.cfg.DisableProgressLogs {
+		args := t.slogArgs
+		args = append(args,
+			slog.Float64("percent", progress.Percent),
+			slog.Duration("time", progress.OutTime),
+			slog.Float64("speed", progress.Speed),
+			slog.Int64("frame", progress.Frame),
+			slog.Duration("eta", progress.ETA),
+		)
+
+		t.gen.logger.LogAttrs(context.Background(), slog.LevelInfo, "Progress update", args...)
+	}
+
+	if t.req.job != nil {
+		t.req.job.setProgress(progress)
+	}
+
+	if t.onProgress != nil {
+		t.onProgress(t.req, progress)
+	}
+}
+
+// listenForProgress parses ffmpeg -progress output, onProgress is called for each progress block
+func listenForProgress(stdout io.Reader, onProgress func(Progress)) {
+	scanner := bufio.NewScanner(stdout)
+
+	var progress Progress
+
+	for scanner.Scan() {
+		if parseProgressLine(&progress, scanner.Text()) {
+			onProgress(progress)
+			progress = Progress{}
+		}
+	}
+}
+
+// parseProgressLine parses ffmpeg -progress key=value line into the progress,
+// true is returned when the line ends the progress block
+func parseProgressLine(progress *Progress, line string) bool {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+	if !ok {
+		return false
+	}
+
+	value = strings.TrimSpace(value)
+
+	switch key {
+	case "frame":
+		progress.Frame, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		progress.FPS, _ = strconv.ParseFloat(value, 64)
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			progress.OutTime = max(time.Duration(us)*time.Microsecond, 0)
+		}
+	case "dup_frames":
+		progress.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "drop_frames":
+		progress.DropFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "speed":
+		progress.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "progress":
+		return true
+	}
+
+	return false
+}
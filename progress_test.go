@@ -0,0 +1,101 @@
+package ffthumbs
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProgressOutput = `frame=120
+fps=59.94
+stream_0_0_q=-0.0
+bitrate=N/A
+total_size=N/A
+out_time_us=4004000
+out_time_ms=4004000
+out_time=00:00:04.004000
+dup_frames=0
+drop_frames=3
+speed=1.99x
+progress=continue
+frame=300
+fps=60.00
+out_time_us=10010000
+out_time=00:00:10.010000
+dup_frames=1
+drop_frames=3
+speed=N/A
+progress=end
+`
+
+func TestListenForProgress(t *testing.T) {
+	var updates []Progress
+
+	listenForProgress(strings.NewReader(testProgressOutput), func(progress Progress) {
+		updates = append(updates, progress)
+	})
+
+	if len(updates) != 2 {
+		t.Fatalf("got %d progress updates, want 2", len(updates))
+	}
+
+	want := Progress{Frame: 120, FPS: 59.94, OutTime: 4004 * time.Millisecond, Speed: 1.99, DropFrames: 3}
+	if updates[0] != want {
+		t.Errorf("got %+v, want %+v", updates[0], want)
+	}
+
+	if updates[1].Frame != 300 || updates[1].DupFrames != 1 || updates[1].Speed != 0 {
+		t.Errorf("unexpected last update: %+v", updates[1])
+	}
+}
+
+func TestProgressTracker(t *testing.T) {
+	gen := &Generator{
+		cfg:    &Config{DisableProgressLogs: true},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	var updates []Progress
+
+	req := &GenerateRequest{
+		OnProgress: func(_ *GenerateRequest, progress Progress) {
+			updates = append(updates, progress)
+		},
+	}
+
+	plan := &requestPlan{
+		duration: 100 * time.Second,
+		segments: []TimeRange{{End: 50 * time.Second}, {Start: 50 * time.Second}},
+	}
+
+	tracker := gen.newProgressTracker(req, plan, nil)
+	tracker.start = time.Now().Add(-10 * time.Second)
+
+	tracker.partListener(0)(Progress{Frame: 100, OutTime: 10 * time.Second, Speed: 2})
+	tracker.partListener(1)(Progress{Frame: 150, OutTime: 15 * time.Second, Speed: 3})
+	tracker.finish()
+
+	if len(updates) != 3 {
+		t.Fatalf("got %d progress updates, want 3", len(updates))
+	}
+
+	last := updates[1]
+	if last.Frame != 250 || last.OutTime != 25*time.Second || last.Speed != 5 || last.Percent != 25 {
+		t.Errorf("unexpected combined progress: %+v", last)
+	}
+
+	// 10s passed for 25%
+	if last.ETA < 29*time.Second || last.ETA > 31*time.Second {
+		t.Errorf("unexpected ETA: %s", last.ETA)
+	}
+
+	if !updates[2].Done || updates[2].Percent != 100 {
+		t.Errorf("unexpected final progress: %+v", updates[2])
+	}
+
+	if gen.newProgressTracker(&GenerateRequest{}, plan, nil) != nil {
+		t.Error("tracker must not be created when progress is neither logged nor reported")
+	}
+}
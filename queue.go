@@ -0,0 +1,389 @@
+package ffthumbs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+)
+
+// ErrQueueFull is returned by TryGenerateAsync when all workers are busy and the queue is full
+var ErrQueueFull = errors.New("generate queue is full")
+
+// requestQueue holds async requests waiting for a free worker, see Config.QueueSize.
+// Requests are dispatched by priority raised with the waiting time (see Config.PriorityAging),
+// then by the earliest deadline, then in the submission order.
+// Requests of the submitters blocked on the full queue wait in the queue too, they aren't counted
+// in the queue size and are admitted in the same order when a worker or a queue slot is released.
+type requestQueue struct {
+	mu sync.Mutex
+
+	items []*queuedRequest
+	// blocked is a number of items of the blocked submitters
+	blocked int
+	size    int
+	aging   time.Duration
+	// running is a number of workers busy with requests
+	running int
+}
+
+type queuedRequest struct {
+	req      *GenerateRequest
+	queuedAt time.Time
+	// admitted is closed when the request of the blocked submitter is queued or taken by a worker,
+	// it is nil for the admitted requests
+	admitted chan struct{}
+}
+
+func newRequestQueue(size int, aging time.Duration) *requestQueue {
+	return &requestQueue{
+		size:  size,
+		aging: aging,
+	}
+}
+
+// push queues the request, the blocked submitter's request isn't counted in the queue size until it is admitted.
+// Must be called under mu.
+func (q *requestQueue) push(req *GenerateRequest, blocked bool) *queuedRequest {
+	item := &queuedRequest{req: req, queuedAt: time.Now()}
+
+	if blocked {
+		item.admitted = make(chan struct{})
+		q.blocked++
+	}
+
+	q.items = append(q.items, item)
+
+	return item
+}
+
+// full tells whether there is no free queue slot, must be called under mu
+func (q *requestQueue) full() bool {
+	return len(q.items)-q.blocked >= q.size
+}
+
+// admit releases the blocked submitter of the item, must be called under mu
+func (q *requestQueue) admit(item *queuedRequest) {
+	if item.admitted == nil {
+		return
+	}
+
+	close(item.admitted)
+	item.admitted = nil
+	q.blocked--
+}
+
+// admitBlocked admits the most urgent requests of the blocked submitters to the free queue slots,
+// must be called under mu
+func (q *requestQueue) admitBlocked() {
+	for q.blocked > 0 && !q.full() {
+		now := time.Now()
+		var best *queuedRequest
+
+		for _, item := range q.items {
+			if item.admitted != nil && (best == nil || q.before(item, best, now)) {
+				best = item
+			}
+		}
+
+		q.admit(best)
+	}
+}
+
+// remove removes the queued request, false is returned when the request isn't queued.
+// The blocked submitter of the removed request is released.
+func (q *requestQueue) remove(req *GenerateRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	idx := slices.IndexFunc(q.items, func(item *queuedRequest) bool {
+		return item.req == req
+	})
+	if idx < 0 {
+		return false
+	}
+
+	q.admit(q.items[idx])
+	q.items = slices.Delete(q.items, idx, idx+1)
+	q.admitBlocked()
+
+	return true
+}
+
+// withdraw removes the request of the blocked submitter, false is returned when the request is already admitted
+func (q *requestQueue) withdraw(item *queuedRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if item.admitted == nil {
+		return false
+	}
+
+	q.items = slices.DeleteFunc(q.items, func(other *queuedRequest) bool {
+		return other == item
+	})
+	q.blocked--
+
+	return true
+}
+
+// next is called by the worker finished its request, the next queued request is returned
+// when the worker should take it, nil is returned when the worker is released
+func (q *requestQueue) next(workers int) *GenerateRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running--
+
+	if len(q.items) == 0 || q.running >= workers {
+		return nil
+	}
+
+	return q.pop()
+}
+
+// popFree returns the next queued request when there is a free worker
+func (q *requestQueue) popFree(workers int) *GenerateRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 || q.running >= workers {
+		return nil
+	}
+
+	return q.pop()
+}
+
+// pop takes the most urgent queued request and accounts its worker, must be called under mu.
+// Effective priorities change over time, so the queue is scanned instead of being kept ordered.
+func (q *requestQueue) pop() *GenerateRequest {
+	now := time.Now()
+	best := 0
+
+	for i := 1; i < len(q.items); i++ {
+		if q.before(q.items[i], q.items[best], now) {
+			best = i
+		}
+	}
+
+	item := q.items[best]
+	q.admit(item)
+	q.items = slices.Delete(q.items, best, best+1)
+	q.running++
+
+	q.admitBlocked()
+
+	return item.req
+}
+
+// priority returns request priority raised by the waiting time
+func (q *requestQueue) priority(item *queuedRequest, now time.Time) int {
+	if q.aging <= 0 {
+		return item.req.Priority
+	}
+
+	return item.req.Priority + int(now.Sub(item.queuedAt)/q.aging)
+}
+
+// before tells whether a should be dispatched before b, earlier queued requests win the ties
+func (q *requestQueue) before(a, b *queuedRequest, now time.Time) bool {
+	if aPriority, bPriority := q.priority(a, now), q.priority(b, now); aPriority != bPriority {
+		return aPriority > bPriority
+	}
+
+	aDeadline, bDeadline := a.req.Deadline, b.req.Deadline
+
+	switch {
+	case aDeadline.IsZero():
+		return false
+	case bDeadline.IsZero():
+		return true
+	default:
+		return aDeadline.Before(bDeadline)
+	}
+}
+
+// QueueDepth returns a number of async requests waiting for a free worker, including the requests
+// of the blocked GenerateAsync callers
+func (g *Generator) QueueDepth() int {
+	g.queue.mu.Lock()
+	defer g.queue.mu.Unlock()
+
+	return len(g.queue.items)
+}
+
+// Running returns a number of async requests being processed
+func (g *Generator) Running() int {
+	g.queue.mu.Lock()
+	defer g.queue.mu.Unlock()
+
+	return g.queue.running
+}
+
+// GenerateAsync is an asynchronous thumbnails generation using the underlying goroutine pool.
+// Concurrency is limited by Config.Concurrency, requests waiting for a free worker are queued (see Config.QueueSize)
+// and dispatched by GenerateRequest.Priority and GenerateRequest.Deadline.
+// When all goroutines in pool are busy and the queue is full this method would block until the request
+// is taken by a free goroutine or admitted to a queue slot, blocked requests are admitted in the same order.
+// ErrGeneratorClosed is returned after Shutdown or Close.
+//
+// The returned Job allows to track, wait and cancel the request, the result is also sent to GenerateRequest.DoneChan.
+// Each request passed to this method will get unique identifier, you can get it by calling GenerateRequest.GetId()
+// or Job.ID().
+func (g *Generator) GenerateAsync(req *GenerateRequest) (*Job, error) {
+	return g.submit(context.Background(), req, true)
+}
+
+// GenerateAsyncCtx is GenerateAsync which stops waiting for a free goroutine or a queue slot when ctx is done,
+// ctx error is returned in that case. ctx only limits the submission, use GenerateRequest.Context or Job.Cancel
+// to cancel processing.
+func (g *Generator) GenerateAsyncCtx(ctx context.Context, req *GenerateRequest) (*Job, error) {
+	return g.submit(ctx, req, true)
+}
+
+// TryGenerateAsync is a non-blocking GenerateAsync, ErrQueueFull is returned when all goroutines in pool
+// are busy and the queue is full
+func (g *Generator) TryGenerateAsync(req *GenerateRequest) (*Job, error) {
+	return g.submit(context.Background(), req, false)
+}
+
+// submit accounts the request and its job and passes the request to a free worker or the queue,
+// duplicates of the in-flight requests are attached to their jobs (see Config.DedupKey)
+func (g *Generator) submit(ctx context.Context, req *GenerateRequest, wait bool) (*Job, error) {
+	if !g.acquire() {
+		return nil, ErrGeneratorClosed
+	}
+
+	job, attached := g.newJob(req)
+	if attached {
+		g.release()
+		return job, nil
+	}
+
+	if err := g.enqueue(ctx, req, wait); err != nil {
+		// Duplicates could be attached to the rejected job meanwhile, they get the error result
+		res := &GenerateResult{Req: req, Err: err}
+
+		if attached := g.finishJob(job, res); len(attached) > 0 {
+			go g.deliverResult(&GenerateRequest{}, res, attached)
+		} else {
+			g.release()
+		}
+
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// enqueue passes the request to a free worker or queues it, when wait is set and the queue is full
+// the request waits in the queue until it is admitted or ctx is done
+func (g *Generator) enqueue(ctx context.Context, req *GenerateRequest, wait bool) error {
+	g.queue.mu.Lock()
+
+	if g.queue.running < g.pool.Cap() {
+		g.queue.running++
+		g.queue.mu.Unlock()
+
+		return g.invoke(req)
+	}
+
+	if !g.queue.full() {
+		g.queue.push(req, false)
+		g.queue.mu.Unlock()
+
+		return nil
+	}
+
+	if !wait {
+		g.queue.mu.Unlock()
+
+		return ErrQueueFull
+	}
+
+	item := g.queue.push(req, true)
+	admitted := item.admitted
+	g.queue.mu.Unlock()
+
+	var err error
+
+	select {
+	case <-admitted:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-g.ctx.Done():
+		err = ErrGeneratorClosed
+	}
+
+	if !g.queue.withdraw(item) {
+		// The request was admitted meanwhile
+		return nil
+	}
+
+	return err
+}
+
+// invoke passes the request with accounted worker to the pool
+func (g *Generator) invoke(req *GenerateRequest) error {
+	if err := g.pool.Invoke(req); err != nil {
+		g.queue.mu.Lock()
+		g.queue.running--
+		g.queue.mu.Unlock()
+
+		if errors.Is(err, ants.ErrPoolClosed) {
+			return ErrGeneratorClosed
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// dispatchQueued passes queued requests to the free workers, e.g. after concurrency increase
+func (g *Generator) dispatchQueued() {
+	for req := g.queue.popFree(g.pool.Cap()); req != nil; req = g.queue.popFree(g.pool.Cap()) {
+		if err := g.invoke(req); err != nil {
+			g.finishAsync(req, &GenerateResult{Req: req, Err: err})
+		}
+	}
+}
+
+func (g *Generator) handleRequest(reqRaw any) {
+	// The worker takes queued requests until the queue is empty
+	for req := reqRaw.(*GenerateRequest); req != nil; req = g.queue.next(g.pool.Cap()) {
+		g.finishAsync(req, g.generateRequest(req))
+	}
+}
+
+// finishAsync completes the request job and delivers async request result
+func (g *Generator) finishAsync(req *GenerateRequest, res *GenerateResult) {
+	var attached []*GenerateRequest
+	if req.job != nil {
+		attached = g.finishJob(req.job, res)
+	}
+
+	g.deliverResult(req, res, attached)
+}
+
+// deliverResult sends the result to DoneChan of the request and the requests attached to its job,
+// the request accounting is released after that
+func (g *Generator) deliverResult(req *GenerateRequest, res *GenerateResult, attached []*GenerateRequest) {
+	defer g.release()
+
+	if req.DoneChan != nil {
+		req.DoneChan <- res
+	}
+
+	// Each attached request gets the result copy describing its own request
+	for _, attachedReq := range attached {
+		attachedRes := *res
+		attachedRes.Req = attachedReq
+
+		attachedReq.DoneChan <- &attachedRes
+	}
+}
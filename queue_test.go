@@ -0,0 +1,252 @@
+package ffthumbs_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codercms/ffthumbs"
+	"github.com/codercms/ffthumbs/ffthumbstest"
+)
+
+// newBlockedGenerator returns generator which ffmpeg runs are blocked until release is closed
+func newBlockedGenerator(t *testing.T, cfg *ffthumbs.Config) (gen *ffthumbs.Generator, release chan struct{}) {
+	t.Helper()
+
+	release = make(chan struct{})
+
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffprobe"), ffthumbstest.Script{
+		Stdout: `{"streams": [{"index": 0, "codec_type": "video"}], "format": {"duration": "10.000000"}}`,
+	})
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+		Stderr: []string{ffthumbstest.ShowInfoLine(0, 0, 0)},
+		Run: func([]string) {
+			<-release
+		},
+	})
+
+	cfg.DisableProgressLogs = true
+
+	return newTestGenerator(t, executor, cfg), release
+}
+
+func TestGeneratorQueue(t *testing.T) {
+	gen, release := newBlockedGenerator(t, &ffthumbs.Config{Concurrency: 1, QueueSize: 1})
+
+	done := make(chan *ffthumbs.GenerateResult, 2)
+
+	if _, err := gen.TryGenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "first.mp4", DoneChan: done}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gen.TryGenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "second.mp4", DoneChan: done}); err != nil {
+		t.Fatal(err)
+	}
+
+	if gen.Running() != 1 || gen.QueueDepth() != 1 {
+		t.Errorf("unexpected running %d and queue depth %d", gen.Running(), gen.QueueDepth())
+	}
+
+	if _, err := gen.TryGenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "third.mp4"}); !errors.Is(err, ffthumbs.ErrQueueFull) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := gen.GenerateAsyncCtx(ctx, &ffthumbs.GenerateRequest{MediaURL: "third.mp4"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if res := <-done; res.Err != nil {
+			t.Errorf("unexpected request error %v", res.Err)
+		}
+	}
+
+	gen.Wait()
+
+	if gen.Running() != 0 || gen.QueueDepth() != 0 {
+		t.Errorf("unexpected running %d and queue depth %d", gen.Running(), gen.QueueDepth())
+	}
+}
+
+func TestGeneratorQueueBlocking(t *testing.T) {
+	gen, release := newBlockedGenerator(t, &ffthumbs.Config{Concurrency: 1})
+
+	done := make(chan *ffthumbs.GenerateResult, 2)
+
+	if _, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "first.mp4", DoneChan: done}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No queue, the worker is busy
+	if _, err := gen.TryGenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "second.mp4"}); !errors.Is(err, ffthumbs.ErrQueueFull) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	submitted := make(chan error, 1)
+
+	go func() {
+		_, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "second.mp4", DoneChan: done})
+		submitted <- err
+	}()
+
+	select {
+	case err := <-submitted:
+		t.Fatalf("GenerateAsync didn't block: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if res := <-done; res.Err != nil {
+			t.Errorf("unexpected request error %v", res.Err)
+		}
+	}
+}
+
+func TestGeneratorQueueConcurrencyIncrease(t *testing.T) {
+	gen, release := newBlockedGenerator(t, &ffthumbs.Config{Concurrency: 1, QueueSize: 2})
+	defer close(release)
+
+	for _, mediaURL := range []string{"first.mp4", "second.mp4", "third.mp4"} {
+		if _, err := gen.TryGenerateAsync(&ffthumbs.GenerateRequest{MediaURL: mediaURL}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gen.SetConcurrency(3)
+
+	if gen.Running() != 3 || gen.QueueDepth() != 0 {
+		t.Errorf("queued requests weren't dispatched, running %d, queue depth %d", gen.Running(), gen.QueueDepth())
+	}
+}
+
+// dispatchOrder submits requests to the generator with the busy single worker and returns their dispatch order
+func dispatchOrder(t *testing.T, cfg *ffthumbs.Config, reqs []*ffthumbs.GenerateRequest, delay time.Duration) []string {
+	t.Helper()
+
+	release := make(chan struct{})
+
+	executor := ffthumbstest.NewExecutor()
+	executor.Handle(ffthumbstest.MatchCommand("ffmpeg"), ffthumbstest.Script{
+		Stderr: []string{ffthumbstest.ShowInfoLine(0, 0, 0)},
+		Run: func([]string) {
+			<-release
+		},
+	})
+
+	cfg.Concurrency = 1
+	cfg.QueueSize = len(reqs)
+	cfg.DisableProgressLogs = true
+
+	gen := newTestGenerator(t, executor, cfg)
+
+	if _, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "busy.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range reqs {
+		if _, err := gen.TryGenerateAsync(req); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(delay)
+	}
+
+	close(release)
+	gen.Wait()
+
+	var order []string
+
+	for _, call := range executor.Calls() {
+		if idx := slices.Index(call.Args, "-i"); idx >= 0 && call.Args[idx+1] != "busy.mp4" {
+			order = append(order, call.Args[idx+1])
+		}
+	}
+
+	return order
+}
+
+func TestGeneratorQueuePriority(t *testing.T) {
+	now := time.Now()
+
+	order := dispatchOrder(t, &ffthumbs.Config{}, []*ffthumbs.GenerateRequest{
+		{MediaURL: "backfill-1.mp4"},
+		{MediaURL: "backfill-2.mp4", Deadline: now.Add(time.Hour)},
+		{MediaURL: "preview-late.mp4", Priority: 10, Deadline: now.Add(time.Minute)},
+		{MediaURL: "preview.mp4", Priority: 10},
+		{MediaURL: "preview-soon.mp4", Priority: 10, Deadline: now.Add(time.Second)},
+	}, 0)
+
+	want := []string{"preview-soon.mp4", "preview-late.mp4", "preview.mp4", "backfill-2.mp4", "backfill-1.mp4"}
+	if !slices.Equal(order, want) {
+		t.Errorf("unexpected dispatch order %v, want %v", order, want)
+	}
+}
+
+func TestGeneratorQueuePriorityAging(t *testing.T) {
+	for _, tt := range []struct {
+		aging time.Duration
+		want  []string
+	}{
+		{aging: 10 * time.Millisecond, want: []string{"backfill.mp4", "preview.mp4"}},
+		{aging: -1, want: []string{"preview.mp4", "backfill.mp4"}},
+	} {
+		t.Run(tt.aging.String(), func(t *testing.T) {
+			order := dispatchOrder(t, &ffthumbs.Config{PriorityAging: tt.aging}, []*ffthumbs.GenerateRequest{
+				{MediaURL: "backfill.mp4"},
+				{MediaURL: "preview.mp4", Priority: 1},
+			}, 50*time.Millisecond)
+
+			if !slices.Equal(order, tt.want) {
+				t.Errorf("unexpected dispatch order %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratorQueueBlockedPriority(t *testing.T) {
+	gen, release := newBlockedGenerator(t, &ffthumbs.Config{Concurrency: 1})
+
+	if _, err := gen.GenerateAsync(&ffthumbs.GenerateRequest{MediaURL: "busy.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No queue, both submitters are blocked until the worker is released, the worker processes one request at a time
+	done := make(chan *ffthumbs.GenerateResult, 2)
+
+	for i, req := range []*ffthumbs.GenerateRequest{
+		{MediaURL: "backfill.mp4", DoneChan: done},
+		{MediaURL: "preview.mp4", Priority: 10, DoneChan: done},
+	} {
+		go func(req *ffthumbs.GenerateRequest) {
+			if _, err := gen.GenerateAsync(req); err != nil {
+				t.Error(err)
+			}
+		}(req)
+
+		for gen.QueueDepth() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(release)
+
+	if res := <-done; res.Req.MediaURL != "preview.mp4" {
+		t.Errorf("%s was dispatched first", res.Req.MediaURL)
+	}
+
+	<-done
+}
@@ -0,0 +1,279 @@
+package ffthumbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetryInitialBackoff is a default delay before the first retry
+	DefaultRetryInitialBackoff = time.Second
+	// DefaultRetryMaxBackoff is a default max delay between attempts
+	DefaultRetryMaxBackoff = 30 * time.Second
+	// DefaultRetryMultiplier is a default backoff growth factor
+	DefaultRetryMultiplier = 2
+)
+
+// DefaultRetryableKinds are the error kinds retried when RetryPolicy.RetryableKinds isn't set
+var DefaultRetryableKinds = []ErrorKind{ErrorKindHTTPServer, ErrorKindNetwork}
+
+// RetryPolicy configures retries of requests failed with transient errors (e.g. HTTP 5xx or connection reset),
+// backoff of attempt N is InitialBackoff * Multiplier^(N-1) capped at MaxBackoff and randomized by Jitter
+type RetryPolicy struct {
+	// MaxAttempts is a max number of attempts including the first one, values <= 1 disable retries
+	MaxAttempts int
+	// InitialBackoff is a delay before the first retry, default: DefaultRetryInitialBackoff
+	InitialBackoff time.Duration
+	// MaxBackoff is a max delay between attempts, default: DefaultRetryMaxBackoff
+	MaxBackoff time.Duration
+	// Multiplier is a backoff growth factor, default: DefaultRetryMultiplier
+	Multiplier float64
+	// Jitter randomizes each backoff by up to the provided fraction (0-1) in both directions,
+	// e.g. 0.2 turns 10s backoff into 8-12s, 0 disables jitter
+	Jitter float64
+	// RetryableKinds lists error kinds (see GenerateError) worth retrying, default: DefaultRetryableKinds.
+	// Cancelled requests are never retried.
+	RetryableKinds []ErrorKind
+}
+
+// isRetryable tells whether the request failed with err should be retried
+func (p *RetryPolicy) isRetryable(err error) bool {
+	var genErr *GenerateError
+	if !errors.As(err, &genErr) || genErr.Kind == ErrorKindCancelled {
+		return false
+	}
+
+	kinds := p.RetryableKinds
+	if kinds == nil {
+		kinds = DefaultRetryableKinds
+	}
+
+	return slices.Contains(kinds, genErr.Kind)
+}
+
+// nextBackoff returns a delay before the next attempt after the failed attempt number attempt (starting from 1),
+// false is returned when the request shouldn't be retried, nil policy never retries
+func (p *RetryPolicy) nextBackoff(attempt int, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || !p.isRetryable(err) {
+		return 0, false
+	}
+
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = DefaultRetryInitialBackoff
+	}
+
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = DefaultRetryMultiplier
+	}
+
+	backoff := min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(maxBackoff))
+
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(backoff), true
+}
+
+// runWithRetry calls attempt until it succeeds or the policy stops retrying, the last error is returned
+func runWithRetry(ctx context.Context, policy *RetryPolicy, logger *slog.Logger, slogArgs []slog.Attr, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		backoff, ok := policy.nextBackoff(n, err)
+		if !ok {
+			return err
+		}
+
+		args := slogArgs
+		args = append(args,
+			slog.Int("attempt", n),
+			slog.Duration("backoff", backoff),
+			slog.String("err", err.Error()),
+		)
+
+		logger.LogAttrs(context.Background(), slog.LevelWarn, "attempt failed, retrying", args...)
+
+		if err := waitBackoff(ctx, backoff); err != nil {
+			return err
+		}
+	}
+}
+
+// waitBackoff sleeps for the backoff, cancelled error is returned when ctx is done earlier
+func waitBackoff(ctx context.Context, backoff time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return newCancelledError(ctx)
+	}
+}
+
+// outputsSnapshot records files found at the request outputs destinations before ffmpeg is launched,
+// so only files written by the failed attempt are removed and results of the previous runs are kept
+type outputsSnapshot struct {
+	// sequences are image sequence patterns (or single file paths) of the outputs
+	sequences []string
+	// files are WebVTT tracks and manifests paths
+	files []string
+	// states are states of the existing files by the cleaned path
+	states map[string]fileState
+}
+
+type fileState struct {
+	size    int64
+	modTime int64
+}
+
+func newFileState(info os.FileInfo) fileState {
+	return fileState{size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// snapshotOutputs records files at the outputs destinations of the request
+func snapshotOutputs(req *GenerateRequest, outputs []*OutputConfig) *outputsSnapshot {
+	var sequences, files []string
+
+	for _, output := range outputs {
+		outputDst := req.getOutputDst(output)
+		sequences = append(sequences, outputDst)
+
+		if output.VTT != nil {
+			files = append(files, req.getVTTDst(output, outputDst))
+		}
+
+		if output.Manifest != nil {
+			files = append(files, req.getManifestDst(output, outputDst))
+		}
+	}
+
+	return newOutputsSnapshot(sequences, files)
+}
+
+// newOutputsSnapshot records existing files of the image sequences and single files
+func newOutputsSnapshot(sequences, files []string) *outputsSnapshot {
+	s := &outputsSnapshot{
+		sequences: sequences,
+		files:     files,
+		states:    make(map[string]fileState),
+	}
+
+	// Whole directories are recorded, so the images following a gap in the sequence are known too
+	dirs := make(map[string]bool)
+
+	for _, dst := range s.sequences {
+		dir := filepath.Dir(dst)
+		if dirs[dir] || !strings.Contains(dst, "%") {
+			continue
+		}
+
+		dirs[dir] = true
+
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+				s.states[filepath.Join(dir, entry.Name())] = newFileState(info)
+			}
+		}
+	}
+
+	s.walk(func(path string, info os.FileInfo) {
+		s.states[filepath.Clean(path)] = newFileState(info)
+	})
+
+	return s
+}
+
+// removeWritten removes files created or modified since the snapshot
+func (s *outputsSnapshot) removeWritten() {
+	s.walk(func(path string, info os.FileInfo) {
+		if state, ok := s.states[filepath.Clean(path)]; !ok || state != newFileState(info) {
+			os.Remove(path)
+		}
+	})
+}
+
+// walk calls fn for the existing output files, image sequences are walked
+// starting from the first image until the first missing one
+func (s *outputsSnapshot) walk(fn func(path string, info os.FileInfo)) {
+	for _, dst := range s.sequences {
+		for num := 1; ; num++ {
+			filename := formatOutputFilename(dst, num)
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				break
+			}
+
+			fn(filename, info)
+
+			if filename == dst {
+				break
+			}
+		}
+	}
+
+	for _, path := range s.files {
+		if info, err := os.Stat(path); err == nil {
+			fn(path, info)
+		}
+	}
+}
+
+// validateRetryPolicy checks retry policy settings, nil policy is valid
+func validateRetryPolicy(policy *RetryPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	if policy.MaxAttempts < 0 || policy.InitialBackoff < 0 || policy.MaxBackoff < 0 || policy.Multiplier < 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeRetryPolicy,
+			Msg:  "retry policy attempts, backoffs and multiplier cannot be negative",
+		}
+	}
+
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return &ValidationError{
+			Type: ValidationErrTypeRetryPolicy,
+			Msg:  fmt.Sprintf("retry policy jitter should be within 0-1, got %g", policy.Jitter),
+		}
+	}
+
+	for _, kind := range policy.RetryableKinds {
+		if kind == ErrorKindCancelled {
+			return &ValidationError{
+				Type: ValidationErrTypeRetryPolicy,
+				Msg:  "cancelled requests cannot be retried",
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package ffthumbs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     3,
+	}
+
+	serverErr := &GenerateError{Kind: ErrorKindHTTPServer}
+	want := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second}
+
+	for i, wantBackoff := range want {
+		backoff, ok := policy.nextBackoff(i+1, serverErr)
+		if !ok || backoff != wantBackoff {
+			t.Errorf("attempt %d: got (%s, %t), want %s", i+1, backoff, ok, wantBackoff)
+		}
+	}
+
+	if _, ok := policy.nextBackoff(5, serverErr); ok {
+		t.Error("attempts limit exceeded")
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if backoff, _ := policy.nextBackoff(1, serverErr); backoff < 50*time.Millisecond || backoff > 150*time.Millisecond {
+			t.Fatalf("backoff %s is out of jitter range", backoff)
+		}
+	}
+
+	var nilPolicy *RetryPolicy
+	if _, ok := nilPolicy.nextBackoff(1, serverErr); ok {
+		t.Error("nil policy retries")
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		err    error
+		want   bool
+	}{
+		{name: "default server error", err: &GenerateError{Kind: ErrorKindHTTPServer}, want: true},
+		{name: "default network error", err: &GenerateError{Kind: ErrorKindNetwork}, want: true},
+		{name: "default client error", err: &GenerateError{Kind: ErrorKindHTTPClient}},
+		{name: "not generate error", err: errors.New("planning failed")},
+		{
+			name:   "custom kinds",
+			policy: RetryPolicy{RetryableKinds: []ErrorKind{ErrorKindInvalidData}},
+			err:    &GenerateError{Kind: ErrorKindInvalidData},
+			want:   true,
+		},
+		{
+			name:   "cancelled",
+			policy: RetryPolicy{RetryableKinds: []ErrorKind{ErrorKindCancelled}},
+			err:    &GenerateError{Kind: ErrorKindCancelled},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.isRetryable(tt.err); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunWithRetry(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	var attempts int
+
+	err := runWithRetry(context.Background(), policy, logger, nil, func() error {
+		attempts++
+		if attempts < 3 {
+			return &GenerateError{Kind: ErrorKindNetwork}
+		}
+
+		return nil
+	})
+
+	if err != nil || attempts != 3 {
+		t.Errorf("got %v after %d attempts", err, attempts)
+	}
+
+	attempts = 0
+
+	err = runWithRetry(context.Background(), policy, logger, nil, func() error {
+		attempts++
+		return &GenerateError{Kind: ErrorKindInputNotFound}
+	})
+
+	if !errors.Is(err, ErrInputNotFound) || attempts != 1 {
+		t.Errorf("got %v after %d attempts", err, attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	policy.InitialBackoff = time.Hour
+	attempts = 0
+
+	err = runWithRetry(ctx, policy, logger, nil, func() error {
+		attempts++
+		cancel()
+
+		return &GenerateError{Kind: ErrorKindHTTPServer}
+	})
+
+	if !errors.Is(err, ErrCancelled) || attempts != 1 {
+		t.Errorf("got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestOutputsSnapshotRemoveWritten(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles := func(content string, names ...string) {
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Results of the previous run
+	writeFiles("old", "0001.jpg", "0002.jpg", "0004.jpg", "sprite.jpg", "manifest.json")
+
+	outputs := []*OutputConfig{
+		{DstPath: filepath.Join(dir, "%04d.jpg"), Manifest: &ManifestConfig{}},
+		{idx: 1, DstPath: filepath.Join(dir, "sprite.jpg"), VTT: &VTTConfig{}},
+	}
+
+	snapshot := snapshotOutputs(&GenerateRequest{}, outputs)
+
+	// The failed attempt rewrote the first image and wrote the next one and the track
+	writeFiles("attempt", "0001.jpg", "0003.jpg", DefaultVTTFilename)
+
+	snapshot.removeWritten()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	want := []string{"0002.jpg", "0004.jpg", "manifest.json", "sprite.jpg"}
+	if !slices.Equal(names, want) {
+		t.Errorf("unexpected files left: %v, want %v", names, want)
+	}
+}
+
+func TestValidateRetryPolicy(t *testing.T) {
+	invalid := []*RetryPolicy{
+		{MaxAttempts: -1},
+		{MaxAttempts: 3, Jitter: 1.5},
+		{MaxAttempts: 3, InitialBackoff: -time.Second},
+		{MaxAttempts: 3, RetryableKinds: []ErrorKind{ErrorKindCancelled}},
+	}
+
+	for _, policy := range invalid {
+		var validationErr *ValidationError
+		if err := validateRetryPolicy(policy); !errors.As(err, &validationErr) || validationErr.Type != ValidationErrTypeRetryPolicy {
+			t.Errorf("policy %+v: unexpected error %v", policy, err)
+		}
+	}
+
+	if err := validateRetryPolicy(&RetryPolicy{MaxAttempts: 3, Jitter: 0.2}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package ffthumbs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// seekRawFramePattern is a pattern of the frames before they are renumbered into the sequence
+const seekRawFramePattern = "raw-%06d.png"
+
+// isSeekable tells whether outputs could be generated with GenerateStrategySeek picked by GenerateStrategyAuto.
+// When outputs aren't resolved (see resolveOutputs) intervals of count and interval rules modes outputs
+// are unknown until media is probed, such outputs are skipped.
+func isSeekable(outputs []*OutputConfig, minInterval time.Duration, resolved bool) bool {
+	for _, output := range outputs {
+		if output.Scene != nil {
+			return false
+		}
+
+		if !resolved && (output.Count > 0 || output.IntervalRules != nil) {
+			continue
+		}
+
+		if output.SnapshotInterval < minInterval {
+			return false
+		}
+	}
+
+	return true
+}
+
+// planSeekPoints returns points on the interval boundaries within duration,
+// limit is a max points number, 0 - no limit
+func planSeekPoints(interval time.Duration, limit int, duration time.Duration) []time.Duration {
+	if interval <= 0 || duration <= 0 {
+		return nil
+	}
+
+	var points []time.Duration
+
+	for point := time.Duration(0); point < duration; point += interval {
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+
+		points = append(points, point)
+	}
+
+	return points
+}
+
+// generateSeek extracts frames of each select group with input seeking into the temp dir
+// and then composes outputs from the extracted frames
+func (g *Generator) generateSeek(req *GenerateRequest, plan *requestPlan, slogArgs []slog.Attr) (*framesCollector, error) {
+	tmpDir, err := g.makeTempDir("ffthumbs-seek-*", slogArgs)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	groupsPTS := make([][]time.Duration, len(plan.framesGroups))
+
+	for i, group := range plan.framesGroups {
+		groupDir := filepath.Join(tmpDir, buildSelectGroupName(i))
+
+		if err := os.Mkdir(groupDir, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create seek temp dir: %w", err)
+		}
+
+		groupsPTS[i], err = g.extractSeekFrames(plan.ctx, req, group.points, groupDir, plan.progress, slogArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return g.composeExtractedFrames(req, plan, tmpDir, groupsPTS, slogArgs)
+}
+
+// extractSeekFrames extracts a frame at each seek point in parallel ffmpeg processes,
+// extracted frames are renumbered into the image sequence in dir, their timestamps are returned.
+// Points without a frame (e.g. past the last frame) are skipped.
+func (g *Generator) extractSeekFrames(parentCtx context.Context, req *GenerateRequest, points []time.Duration, dir string, progress *progressTracker, slogArgs []slog.Attr) ([]time.Duration, error) {
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	pts := make([]time.Duration, len(points))
+	extracted := make([]bool, len(points))
+	sem := make(chan struct{}, g.cfg.getSeekConcurrency())
+
+	for k, point := range points {
+		sem <- struct{}{}
+
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+
+		go func(k int, point time.Duration) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			framePTS, ok, err := g.extractSeekFrame(ctx, req.MediaURL, point, filepath.Join(dir, fmt.Sprintf(seekRawFramePattern, k)), slogArgs)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+
+				return
+			}
+
+			pts[k] = framePTS
+			extracted[k] = ok
+
+			progress.pointDone()
+		}(k, point)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if err := newCancelledError(parentCtx); err != nil {
+		return nil, err
+	}
+
+	var seqPTS []time.Duration
+
+	for k := range points {
+		if !extracted[k] {
+			continue
+		}
+
+		src := filepath.Join(dir, fmt.Sprintf(seekRawFramePattern, k))
+		dst := filepath.Join(dir, fmt.Sprintf(extractedFramePattern, len(seqPTS)+1))
+
+		if err := os.Rename(src, dst); err != nil {
+			return nil, fmt.Errorf("cannot rename extracted frame: %w", err)
+		}
+
+		seqPTS = append(seqPTS, pts[k])
+	}
+
+	return seqPTS, nil
+}
+
+// extractSeekFrame extracts the first frame at or after the point into dst,
+// ok is false when there is no frame after the point
+func (g *Generator) extractSeekFrame(ctx context.Context, mediaURL string, point time.Duration, dst string, slogArgs []slog.Attr) (pts time.Duration, ok bool, err error) {
+	seek := strconv.FormatFloat(point.Seconds(), 'f', 6, 64)
+
+	cmdArgs := slices.Clone(g.cmdArgs)
+	cmdArgs = append(cmdArgs, g.inputArgs...)
+
+	if g.cfg.KeyframesOnly {
+		cmdArgs = append(cmdArgs, "-skip_frame", "nokey")
+	}
+
+	cmdArgs = append(cmdArgs,
+		"-ss", seek,
+		"-i", mediaURL,
+		"-map", "0:v:0",
+		"-frames:v", "1",
+		"-vf", buildShowInfoFilter(&OutputConfig{}).String(),
+		"-update", "1",
+		dst,
+	)
+
+	args := slogArgs
+	args = append(args, slog.String("seek", seek))
+
+	collector, err := g.runFfmpeg(ctx, cmdArgs, nil, args)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(collector.frames) == 0 {
+		return 0, false, nil
+	}
+
+	// Input timestamps are shifted to the seek point
+	return point + collector.frames[0].pts, true, nil
+}
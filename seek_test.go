@@ -0,0 +1,127 @@
+package ffthumbs
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPlanSeekPoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		limit    int
+		duration time.Duration
+		want     []time.Duration
+	}{
+		{
+			name:     "interval",
+			interval: 30 * time.Second,
+			duration: 100 * time.Second,
+			want:     []time.Duration{0, 30 * time.Second, 60 * time.Second, 90 * time.Second},
+		},
+		{
+			name:     "duration on the boundary",
+			interval: 30 * time.Second,
+			duration: 90 * time.Second,
+			want:     []time.Duration{0, 30 * time.Second, 60 * time.Second},
+		},
+		{
+			name:     "limit",
+			interval: 25 * time.Second,
+			limit:    2,
+			duration: 100 * time.Second,
+			want:     []time.Duration{0, 25 * time.Second},
+		},
+		{
+			name:     "unknown duration",
+			interval: 30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planSeekPoints(tt.interval, tt.limit, tt.duration); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFilterGraphSeekInputs(t *testing.T) {
+	outputs := []*OutputConfig{
+		{Type: OutputTypeThumbs, SnapshotInterval: time.Minute, Scale: ScaleConfig{Width: 320, Height: 180}},
+		{
+			Type:             OutputTypeSprites,
+			SnapshotInterval: 30 * time.Second,
+			Scale:            ScaleConfig{Width: 160, Height: 90},
+			Sprites:          SpritesConfig{Dimensions: SpriteDimensions{Columns: 5, Rows: 5}},
+		},
+	}
+
+	opts := &graphOptions{extractedInputs: true}
+
+	graph, err := buildFilterGraph(outputs, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `[0:v]scale=320:180,showinfo@frames-0[thumbs-0-out];` +
+		`[1:v]scale=160:90,showinfo@frames-1,tile=5x5[sprites-1-out]`
+
+	if got := graph.String(); got != want {
+		t.Errorf("filters mismatch\ngot:  %s\nwant: %s", got, want)
+	}
+
+	groups := planFramesGroups(outputs, opts)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 frames groups, got %d", len(groups))
+	}
+
+	if !slices.Equal(groups[0].outputs, []int{0}) || !slices.Equal(groups[1].outputs, []int{1}) {
+		t.Errorf("unexpected groups outputs: %v, %v", groups[0].outputs, groups[1].outputs)
+	}
+
+	collector := &framesCollector{frames: []capturedFrame{
+		{outputIdx: 0, n: 1, pts: time.Second},
+		{outputIdx: 1, n: 2, pts: 2 * time.Second},
+	}}
+
+	collector.applyExtractedTimestamps(groups, [][]time.Duration{
+		{40 * time.Millisecond, time.Minute},
+		{0, 30 * time.Second, time.Minute + 20*time.Millisecond},
+	})
+
+	if collector.frames[0].pts != time.Minute || collector.frames[1].pts != time.Minute+20*time.Millisecond {
+		t.Errorf("unexpected frames timestamps: %+v", collector.frames)
+	}
+}
+
+func TestIsSeekable(t *testing.T) {
+	outputs := []*OutputConfig{
+		{SnapshotInterval: time.Minute},
+		{Count: 10},
+	}
+
+	if !isSeekable(outputs, DefaultSeekMinInterval, false) {
+		t.Error("expected outputs to be seekable")
+	}
+
+	if isSeekable(append(outputs, &OutputConfig{SnapshotInterval: time.Second}), DefaultSeekMinInterval, false) {
+		t.Error("short interval output must not be seekable")
+	}
+
+	if isSeekable(append(outputs, &OutputConfig{Scene: &SceneConfig{}}), DefaultSeekMinInterval, false) {
+		t.Error("scene output must not be seekable")
+	}
+
+	// 1000 frames of 10 minutes media are 600ms apart
+	resolved, err := resolveOutputs([]*OutputConfig{{Count: 1000}}, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if isSeekable(resolved, DefaultSeekMinInterval, true) {
+		t.Error("resolved count output with short interval must not be seekable")
+	}
+}
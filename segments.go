@@ -0,0 +1,268 @@
+package ffthumbs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// planSegments splits media window of provided duration into segmentsNum segments, the boundaries are placed
+// on the interval boundaries of every frames group, so a frame is selected regardless of the segment it belongs to.
+// Boundaries which cannot be aligned are dropped, so fewer segments could be returned.
+func planSegments(groups []*framesGroup, duration time.Duration, segmentsNum int) []TimeRange {
+	if len(groups) == 0 || duration <= 0 || segmentsNum <= 1 {
+		return nil
+	}
+
+	// Boundaries are looked up on the interval boundaries of the longest interval group
+	base := groups[0].selection
+	for _, group := range groups[1:] {
+		if group.selection.interval > base.interval {
+			base = group.selection
+		}
+	}
+
+	if base.interval <= 0 {
+		return nil
+	}
+
+	var boundaries []time.Duration
+
+	for j := 1; j < segmentsNum; j++ {
+		target := duration * time.Duration(j) / time.Duration(segmentsNum)
+		if len(boundaries) > 0 {
+			target = max(target, boundaries[len(boundaries)-1]+1)
+		}
+
+		boundary := (target + base.interval - 1) / base.interval * base.interval
+
+		for ; boundary < duration; boundary += base.interval {
+			if isSegmentBoundary(groups, boundary) {
+				boundaries = append(boundaries, boundary)
+				break
+			}
+		}
+	}
+
+	if len(boundaries) == 0 {
+		return nil
+	}
+
+	segments := make([]TimeRange, 0, len(boundaries)+1)
+
+	var start time.Duration
+
+	for _, boundary := range boundaries {
+		segments = append(segments, TimeRange{Start: start, End: boundary})
+		start = boundary
+	}
+
+	return append(segments, TimeRange{Start: start})
+}
+
+// isSegmentBoundary tells whether the boundary is placed on the interval boundary of every frames group
+func isSegmentBoundary(groups []*framesGroup, boundary time.Duration) bool {
+	for _, group := range groups {
+		if boundary%group.selection.interval != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// buildSegmentFilterGraph builds filter graph which selects frames of each frames group within the segment,
+// frames of group i are passed to the [sel-i-out] output, skip ranges are relative to the segment start,
+// keyframes of opts are used in keyframes only mode
+func buildSegmentFilterGraph(groups []*framesGroup, segment TimeRange, skip []TimeRange, opts *graphOptions) *FilterGraph {
+	graph := &FilterGraph{}
+
+	var inputFilters []*Filter
+	if len(skip) > 0 {
+		inputFilters = append(inputFilters, buildSkipRangesFilter(skip))
+	}
+
+	inputs := []string{"0:v"}
+
+	if len(groups) > 1 {
+		inputs = make([]string, 0, len(groups))
+		for i := range groups {
+			inputs = append(inputs, buildSelectGroupName(i))
+		}
+
+		graph.AddChain([]string{"0:v"}, inputs, append(inputFilters, buildSplitFilter(len(inputs)))...)
+		inputFilters = nil
+	}
+
+	for i, group := range groups {
+		expr := buildAlignedSelectExpr(&group.selection, segment.Start)
+		if group.selection.snapToKeyframes {
+			expr = buildKeyframesSelectExpr(segmentKeyframes(selectNearestKeyframes(opts.keyframes, &group.selection, opts.windowDuration), segment), segment.Start)
+		}
+
+		filters := append(inputFilters,
+			NewFilter("select", Arg(expr)),
+			NewFilter("showinfo").WithInstance("frames-"+strconv.Itoa(i)),
+		)
+
+		graph.AddChain([]string{inputs[i]}, []string{buildSelectGroupName(i) + "-out"}, filters...)
+	}
+
+	return graph
+}
+
+// segmentKeyframes returns the sorted keyframes within the segment
+func segmentKeyframes(keyframes []time.Duration, segment TimeRange) []time.Duration {
+	from, _ := slices.BinarySearch(keyframes, segment.Start)
+	to := len(keyframes)
+
+	if segment.End > 0 {
+		to, _ = slices.BinarySearch(keyframes, segment.End)
+	}
+
+	return keyframes[from:to]
+}
+
+// generateSegments extracts selected frames of each time segment in parallel ffmpeg processes,
+// merges them into the frames groups sequences and then composes outputs from the extracted frames
+func (g *Generator) generateSegments(req *GenerateRequest, plan *requestPlan, slogArgs []slog.Attr) (*framesCollector, error) {
+	tmpDir, err := g.makeTempDir("ffthumbs-segments-*", slogArgs)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	parentCtx := plan.ctx
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	collectors := make([]*framesCollector, len(plan.segments))
+
+	for j, segment := range plan.segments {
+		wg.Add(1)
+
+		go func(j int, segment TimeRange) {
+			defer wg.Done()
+
+			collector, err := g.extractSegmentFrames(ctx, req, plan, j, filepath.Join(tmpDir, buildSegmentName(j)), slogArgs)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+
+				return
+			}
+
+			collectors[j] = collector
+		}(j, segment)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if err := newCancelledError(parentCtx); err != nil {
+		return nil, err
+	}
+
+	groupsPTS, err := mergeSegmentsFrames(tmpDir, plan, collectors)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.composeExtractedFrames(req, plan, tmpDir, groupsPTS, slogArgs)
+}
+
+// extractSegmentFrames extracts selected frames of each frames group within the segment into the segment dir
+func (g *Generator) extractSegmentFrames(ctx context.Context, req *GenerateRequest, plan *requestPlan, segmentIdx int, segmentDir string, slogArgs []slog.Attr) (*framesCollector, error) {
+	segment := plan.segments[segmentIdx]
+
+	cmdArgs := slices.Clone(g.cmdArgs)
+	cmdArgs = append(cmdArgs, g.inputArgs...)
+
+	if g.cfg.KeyframesOnly {
+		cmdArgs = append(cmdArgs, "-skip_frame", "nokey")
+	}
+
+	cmdArgs = append(cmdArgs, plan.inputWindowArgs(segment)...)
+	cmdArgs = append(cmdArgs, "-i", req.MediaURL)
+
+	skip := shiftTimeRanges(plan.skip, plan.start+segment.Start)
+	cmdArgs = append(cmdArgs, "-filter_complex", buildSegmentFilterGraph(plan.framesGroups, segment, skip, plan.graphOpts).String())
+	cmdArgs = append(cmdArgs, "-vsync", "0")
+
+	for i := range plan.framesGroups {
+		groupDir := filepath.Join(segmentDir, buildSelectGroupName(i))
+
+		if err := os.MkdirAll(groupDir, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create segment temp dir: %w", err)
+		}
+
+		cmdArgs = append(cmdArgs,
+			"-map", fmt.Sprintf("[%s-out]", buildSelectGroupName(i)),
+			filepath.Join(groupDir, extractedFramePattern),
+		)
+	}
+
+	args := slogArgs
+	args = append(args, slog.Int("segment", segmentIdx))
+
+	return g.runFfmpeg(ctx, cmdArgs, plan.progress.partListener(segmentIdx), args)
+}
+
+// mergeSegmentsFrames moves frames extracted by segments into the frames groups sequences in the segments order,
+// timestamps of the merged frames are returned
+func mergeSegmentsFrames(tmpDir string, plan *requestPlan, collectors []*framesCollector) ([][]time.Duration, error) {
+	groupsPTS := make([][]time.Duration, len(plan.framesGroups))
+
+	for i := range plan.framesGroups {
+		groupDir := filepath.Join(tmpDir, buildSelectGroupName(i))
+
+		if err := os.Mkdir(groupDir, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create segments temp dir: %w", err)
+		}
+
+		for j, collector := range collectors {
+			segment := plan.segments[j]
+			segmentGroupDir := filepath.Join(tmpDir, buildSegmentName(j), buildSelectGroupName(i))
+
+			for _, frame := range collector.frames {
+				if frame.outputIdx != i {
+					continue
+				}
+
+				src := filepath.Join(segmentGroupDir, fmt.Sprintf(extractedFramePattern, frame.n+1))
+				dst := filepath.Join(groupDir, fmt.Sprintf(extractedFramePattern, len(groupsPTS[i])+1))
+
+				if err := os.Rename(src, dst); err != nil {
+					return nil, fmt.Errorf("cannot move segment frame: %w", err)
+				}
+
+				// Input timestamps are shifted to the segment start
+				groupsPTS[i] = append(groupsPTS[i], plan.start+segment.Start+frame.pts)
+			}
+		}
+	}
+
+	return groupsPTS, nil
+}
+
+func buildSegmentName(segmentIdx int) string {
+	return "seg-" + strconv.Itoa(segmentIdx)
+}
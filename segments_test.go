@@ -0,0 +1,87 @@
+package ffthumbs
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPlanSegments(t *testing.T) {
+	tests := []struct {
+		name       string
+		selections []frameSelection
+		duration   time.Duration
+		segments   int
+		want       []TimeRange
+	}{
+		{
+			name:       "aligned to interval",
+			selections: []frameSelection{{interval: 7 * time.Second}},
+			duration:   100 * time.Second,
+			segments:   4,
+			want: []TimeRange{
+				{Start: 0, End: 28 * time.Second},
+				{Start: 28 * time.Second, End: 56 * time.Second},
+				{Start: 56 * time.Second, End: 77 * time.Second},
+				{Start: 77 * time.Second},
+			},
+		},
+		{
+			name:       "common boundaries of groups",
+			selections: []frameSelection{{interval: 2 * time.Second}, {interval: 3 * time.Second}},
+			duration:   60 * time.Second,
+			segments:   3,
+			want: []TimeRange{
+				{Start: 0, End: 24 * time.Second},
+				{Start: 24 * time.Second, End: 42 * time.Second},
+				{Start: 42 * time.Second},
+			},
+		},
+		{
+			name:       "keyframes ranges",
+			selections: []frameSelection{{interval: 10 * time.Second, snapToKeyframes: true}},
+			duration:   60 * time.Second,
+			segments:   2,
+			want: []TimeRange{
+				{Start: 0, End: 30 * time.Second},
+				{Start: 30 * time.Second},
+			},
+		},
+		{
+			name:       "too short media",
+			selections: []frameSelection{{interval: time.Minute}},
+			duration:   50 * time.Second,
+			segments:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := make([]*framesGroup, 0, len(tt.selections))
+			for _, selection := range tt.selections {
+				groups = append(groups, &framesGroup{selection: selection})
+			}
+
+			if got := planSegments(groups, tt.duration, tt.segments); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSegmentFilterGraph(t *testing.T) {
+	groups := []*framesGroup{
+		{selection: frameSelection{interval: 5 * time.Second}},
+		{selection: frameSelection{interval: 2 * time.Second, limit: 10}},
+	}
+
+	graph := buildSegmentFilterGraph(groups, TimeRange{Start: 20 * time.Second, End: 40 * time.Second}, nil, &graphOptions{})
+
+	want := `[0:v]split=2[sel-0][sel-1];` +
+		`[sel-0]select=bitor(isnan(prev_selected_t)\,gt(floor((t+20)/5)\,floor((prev_selected_t+20)/5))),showinfo@frames-0[sel-0-out];` +
+		`[sel-1]select=lt(floor((t+20)/2)\,10)*bitor(isnan(prev_selected_t)\,gt(floor((t+20)/2)\,floor((prev_selected_t+20)/2))),showinfo@frames-1[sel-1-out]`
+
+	if got := graph.String(); got != want {
+		t.Errorf("filters mismatch\ngot:  %s\nwant: %s", got, want)
+	}
+}
@@ -0,0 +1,416 @@
+package ffthumbs
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selectExprEvaluator evaluates the subset of ffmpeg expressions used by the select filters
+type selectExprEvaluator struct {
+	src  string
+	pos  int
+	vars map[string]float64
+}
+
+func evalSelectExpr(expr string, vars map[string]float64) (float64, error) {
+	e := &selectExprEvaluator{src: expr, vars: vars}
+
+	val, err := e.parseSum()
+	if err != nil {
+		return 0, err
+	}
+
+	if e.pos != len(e.src) {
+		return 0, fmt.Errorf("unexpected %q at %d", e.src[e.pos:], e.pos)
+	}
+
+	return val, nil
+}
+
+func (e *selectExprEvaluator) parseSum() (float64, error) {
+	val, err := e.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+
+	for e.pos < len(e.src) && (e.src[e.pos] == '+' || e.src[e.pos] == '-') {
+		op := e.src[e.pos]
+		e.pos++
+
+		rhs, err := e.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '+' {
+			val += rhs
+		} else {
+			val -= rhs
+		}
+	}
+
+	return val, nil
+}
+
+func (e *selectExprEvaluator) parseProduct() (float64, error) {
+	val, err := e.parseOperand()
+	if err != nil {
+		return 0, err
+	}
+
+	for e.pos < len(e.src) && (e.src[e.pos] == '*' || e.src[e.pos] == '/') {
+		op := e.src[e.pos]
+		e.pos++
+
+		rhs, err := e.parseOperand()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '*' {
+			val *= rhs
+		} else {
+			val /= rhs
+		}
+	}
+
+	return val, nil
+}
+
+func (e *selectExprEvaluator) parseOperand() (float64, error) {
+	if e.pos >= len(e.src) {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+
+	if e.src[e.pos] == '(' {
+		e.pos++
+
+		val, err := e.parseSum()
+		if err != nil {
+			return 0, err
+		}
+
+		return val, e.expect(')')
+	}
+
+	start := e.pos
+	for e.pos < len(e.src) && strings.ContainsRune("abcdefghijklmnopqrstuvwxyz_0123456789.", rune(e.src[e.pos])) {
+		e.pos++
+	}
+
+	name := e.src[start:e.pos]
+	if len(name) == 0 {
+		return 0, fmt.Errorf("unexpected %q at %d", e.src[e.pos:], e.pos)
+	}
+
+	if num, err := strconv.ParseFloat(name, 64); err == nil {
+		return num, nil
+	}
+
+	if e.pos >= len(e.src) || e.src[e.pos] != '(' {
+		val, ok := e.vars[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown variable %s", name)
+		}
+
+		return val, nil
+	}
+
+	e.pos++
+
+	var args []float64
+
+	for {
+		arg, err := e.parseSum()
+		if err != nil {
+			return 0, err
+		}
+
+		args = append(args, arg)
+
+		if e.pos < len(e.src) && e.src[e.pos] == ',' {
+			e.pos++
+			continue
+		}
+
+		if err := e.expect(')'); err != nil {
+			return 0, err
+		}
+
+		break
+	}
+
+	return callSelectExprFunc(name, args)
+}
+
+func (e *selectExprEvaluator) expect(c byte) error {
+	if e.pos >= len(e.src) || e.src[e.pos] != c {
+		return fmt.Errorf("expected %q at %d", c, e.pos)
+	}
+
+	e.pos++
+
+	return nil
+}
+
+func callSelectExprFunc(name string, args []float64) (float64, error) {
+	boolVal := func(b bool) float64 {
+		if b {
+			return 1
+		}
+
+		return 0
+	}
+
+	switch {
+	case name == "isnan" && len(args) == 1:
+		return boolVal(math.IsNaN(args[0])), nil
+	case name == "abs" && len(args) == 1:
+		return math.Abs(args[0]), nil
+	case name == "floor" && len(args) == 1:
+		return math.Floor(args[0]), nil
+	case name == "gt" && len(args) == 2:
+		return boolVal(args[0] > args[1]), nil
+	case name == "gte" && len(args) == 2:
+		return boolVal(args[0] >= args[1]), nil
+	case name == "lt" && len(args) == 2:
+		return boolVal(args[0] < args[1]), nil
+	case name == "bitor" && len(args) == 2:
+		return float64(int64(args[0]) | int64(args[1])), nil
+	default:
+		return 0, fmt.Errorf("unknown function %s/%d", name, len(args))
+	}
+}
+
+// simulateSelect applies select filter expression to the frames timestamps (in seconds) like ffmpeg does
+func simulateSelect(t *testing.T, filter *Filter, timestamps []float64) []float64 {
+	t.Helper()
+
+	arg, _ := filter.Arg(0)
+	expr := arg.(string)
+
+	var selected []float64
+
+	prevSelectedT := math.NaN()
+
+	for n, ts := range timestamps {
+		val, err := evalSelectExpr(expr, map[string]float64{
+			"t":               ts,
+			"n":               float64(n),
+			"prev_selected_t": prevSelectedT,
+			"selected_n":      float64(len(selected)),
+		})
+		if err != nil {
+			t.Fatalf("cannot evaluate %s: %v", expr, err)
+		}
+
+		if val != 0 && !math.IsNaN(val) {
+			selected = append(selected, ts)
+			prevSelectedT = ts
+		}
+	}
+
+	return selected
+}
+
+// checkAlignedFrames checks that frame N of the selected frames is the first frame at or after start + K*interval,
+// where K is the N-th interval range containing any frame
+func checkAlignedFrames(t *testing.T, timestamps, selected []float64, start, interval float64) {
+	t.Helper()
+
+	var want []float64
+
+	lastRange := math.Inf(-1)
+
+	for _, ts := range timestamps {
+		if r := math.Floor((ts - start) / interval); r > lastRange {
+			want = append(want, ts)
+			lastRange = r
+		}
+	}
+
+	if len(selected) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(selected), len(want))
+	}
+
+	for i := range want {
+		if selected[i] != want[i] {
+			t.Fatalf("frame %d: got %g, want %g", i, selected[i], want[i])
+		}
+	}
+}
+
+func buildTimestamps(start float64, durations ...[2]float64) []float64 {
+	var timestamps []float64
+
+	ts := start
+
+	// Each pair is a frame duration and a number of frames
+	for _, d := range durations {
+		for i := 0; i < int(d[1]); i++ {
+			timestamps = append(timestamps, ts)
+			ts += d[0]
+		}
+	}
+
+	return timestamps
+}
+
+func TestAlignedSelectDrift(t *testing.T) {
+	// 29.97 fps, 10 minutes
+	timestamps := buildTimestamps(0, [2]float64{1001.0 / 30000, 17982})
+	selection := frameSelection{interval: time.Second}
+
+	drifting := simulateSelect(t, buildSelectFramesFilter(&selection, &graphOptions{}), timestamps)
+	if last := drifting[len(drifting)-1]; last-float64(len(drifting)-1) < 10*1001.0/30000 {
+		t.Fatalf("expected drifting interval selection, frame %d is at %g", len(drifting)-1, last)
+	}
+
+	selection.aligned = true
+	aligned := simulateSelect(t, buildSelectFramesFilter(&selection, &graphOptions{}), timestamps)
+
+	for n, ts := range aligned {
+		if ts < float64(n) || ts-float64(n) >= 1001.0/30000 {
+			t.Fatalf("frame %d is at %g", n, ts)
+		}
+	}
+
+	checkAlignedFrames(t, timestamps, aligned, 0, 1)
+}
+
+func TestAlignedSelectVFR(t *testing.T) {
+	timestamps := buildTimestamps(0,
+		[2]float64{1.0 / 30, 95},
+		[2]float64{1.0 / 24, 70},
+		// Frames gap longer than the interval
+		[2]float64{4.3, 1},
+		[2]float64{1.0 / 60, 200},
+		[2]float64{0.7, 10},
+	)
+
+	for _, interval := range []time.Duration{time.Second, 2500 * time.Millisecond} {
+		t.Run(interval.String(), func(t *testing.T) {
+			selection := frameSelection{interval: interval, aligned: true}
+			selected := simulateSelect(t, buildSelectFramesFilter(&selection, &graphOptions{}), timestamps)
+
+			checkAlignedFrames(t, timestamps, selected, 0, interval.Seconds())
+		})
+	}
+
+	selection := frameSelection{interval: time.Second, limit: 5, aligned: true}
+	if selected := simulateSelect(t, buildSelectFramesFilter(&selection, &graphOptions{}), timestamps); len(selected) != 5 {
+		t.Errorf("expected 5 frames within the limit, got %v", selected)
+	}
+}
+
+func TestAlignedSelectNonZeroStart(t *testing.T) {
+	// The first video frame is after the timeline start (e.g. audio starts earlier)
+	timestamps := buildTimestamps(0.48, [2]float64{0.04, 500})
+
+	selection := frameSelection{interval: time.Second, limit: 10, aligned: true}
+	selected := simulateSelect(t, buildSelectFramesFilter(&selection, &graphOptions{}), timestamps)
+
+	// Frames of the first 10 interval ranges
+	checkAlignedFrames(t, timestamps[:238], selected, 0, 1)
+
+	if len(selected) != 10 || selected[0] != timestamps[0] {
+		t.Errorf("unexpected frames: %v", selected)
+	}
+
+	// Timestamps of the window started at 90.5s are shifted by ffmpeg, offset restores original timestamps
+	timestamps = buildTimestamps(0.02, [2]float64{1.0 / 25, 700})
+	offset := 90500 * time.Millisecond
+
+	selection = frameSelection{interval: 2 * time.Second, aligned: true}
+	selected = simulateSelect(t, NewFilter("select", Arg(buildAlignedSelectExpr(&selection, offset))), timestamps)
+
+	shifted := make([]float64, len(timestamps))
+	for i, ts := range timestamps {
+		shifted[i] = ts + offset.Seconds()
+	}
+
+	for i := range selected {
+		selected[i] += offset.Seconds()
+	}
+
+	checkAlignedFrames(t, shifted, selected, 0, 2)
+}
+
+func TestBuildSpriteVTTCuesAligned(t *testing.T) {
+	tile := &TilePosition{Width: 160, Height: 90}
+	frames := []FrameInfo{
+		{File: "sprites/0001.jpg", PTS: 520 * time.Millisecond, Tile: tile},
+		{File: "sprites/0001.jpg", PTS: 2040 * time.Millisecond, Index: 1, Tile: tile},
+		{File: "sprites/0001.jpg", PTS: 6 * time.Second, Index: 2, Tile: tile},
+	}
+
+	cues := buildSpriteVTTCues(&spriteVTTParams{
+		VTTDir:   "sprites",
+		Interval: 2 * time.Second,
+		Aligned:  true,
+		Duration: 7 * time.Second,
+	}, frames)
+
+	want := []TimeRange{
+		{Start: 0, End: 2 * time.Second},
+		{Start: 2 * time.Second, End: 6 * time.Second},
+		{Start: 6 * time.Second, End: 7 * time.Second},
+	}
+
+	if len(cues) != len(want) {
+		t.Fatalf("got %d cues, want %d", len(cues), len(want))
+	}
+
+	for i, cue := range cues {
+		if cue.Start != want[i].Start || cue.End != want[i].End {
+			t.Errorf("cue %d: got %s-%s, want %s-%s", i, cue.Start, cue.End, want[i].Start, want[i].End)
+		}
+	}
+}
+
+func TestKeyframesSelectNearest(t *testing.T) {
+	// Keyframes of irregular GOPs, the one after the 10s boundary is nearer than the one before it
+	keyframes := []float64{0, 2.5, 4.5, 8.5, 10.4, 14.96, 17.2, 21.2, 26.4, 29.9}
+
+	probed := make([]time.Duration, 0, len(keyframes))
+	for _, ts := range keyframes {
+		// Probed timestamps are rounded to microseconds
+		probed = append(probed, durationFromSeconds(ts+4e-7))
+	}
+
+	tests := []struct {
+		name      string
+		selection frameSelection
+		want      []float64
+	}{
+		{
+			name:      "nearest to boundaries",
+			selection: frameSelection{interval: 5 * time.Second, snapToKeyframes: true},
+			want:      []float64{0, 4.5, 10.4, 14.96, 21.2, 26.4, 29.9},
+		},
+		{
+			name:      "keyframe nearest to several boundaries",
+			selection: frameSelection{interval: 2 * time.Second, snapToKeyframes: true, limit: 6},
+			want:      []float64{0, 2.5, 4.5, 8.5, 10.4},
+		},
+		{
+			name:      "limit",
+			selection: frameSelection{interval: 10 * time.Second, snapToKeyframes: true, limit: 2},
+			want:      []float64{0, 10.4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := simulateSelect(t, buildSelectFramesFilter(&tt.selection, &graphOptions{keyframes: probed, windowDuration: 31 * time.Second}), keyframes)
+			if !slices.Equal(selected, tt.want) {
+				t.Errorf("got %v, want %v", selected, tt.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,8 @@ package ffthumbs
 
 import (
 	"context"
+	"io"
 	"log/slog"
-	"os/exec"
 	"path"
 	"strings"
 	"time"
@@ -12,6 +12,8 @@ import (
 type launchParams struct {
 	ctx context.Context
 
+	executor Executor
+
 	path string
 	args []string
 
@@ -25,29 +27,26 @@ type launchParams struct {
 
 var logCtx = context.Background()
 
-func launchCommand(params launchParams) (*exec.Cmd, error) {
-	var cmd *exec.Cmd
-
-	if params.ctx != nil {
-		cmd = exec.CommandContext(params.ctx, params.path, params.args...)
-	} else {
-		cmd = exec.Command(params.path, params.args...)
-	}
-
-	var stderr strings.Builder // or bytes.Buffer
-	cmd.Stderr = &stderr
+// launchCommand runs the command and waits for its completion, stdout is returned when requested
+func launchCommand(params launchParams) (string, error) {
+	proc := params.executor.Command(params.ctx, params.path, params.args...)
 
 	cmdName := path.Base(params.path)
 
-	if params.needStdout {
-		var stdout strings.Builder
-		cmd.Stdout = &stdout
+	stdoutPipe, err := proc.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+
+	stderrPipe, err := proc.StderrPipe()
+	if err != nil {
+		return "", err
 	}
 
 	if params.logger != nil {
 		args := params.LogArgs
 		args = append(args,
-			slog.String("cmd", cmd.String()),
+			slog.String("cmd", proc.String()),
 		)
 
 		params.logger.LogAttrs(logCtx, slog.LevelDebug, "Launching "+cmdName, args...)
@@ -55,7 +54,7 @@ func launchCommand(params launchParams) (*exec.Cmd, error) {
 
 	start := time.Now()
 
-	if err := cmd.Start(); err != nil {
+	if err := proc.Start(); err != nil {
 		if params.logger != nil {
 			args := params.LogArgs
 			args = append(args,
@@ -65,10 +64,30 @@ func launchCommand(params launchParams) (*exec.Cmd, error) {
 			params.logger.LogAttrs(logCtx, slog.LevelError, cmdName+" start failed", args...)
 		}
 
-		return cmd, err
+		return "", newCommandError(params.ctx, cmdName, err, "")
 	}
 
-	if err := cmd.Wait(); err != nil {
+	var stdout, stderr strings.Builder
+	stderrDone := make(chan struct{})
+
+	go func() {
+		defer close(stderrDone)
+
+		io.Copy(&stderr, stderrPipe)
+	}()
+
+	if params.needStdout {
+		io.Copy(&stdout, stdoutPipe)
+	} else {
+		io.Copy(io.Discard, stdoutPipe)
+	}
+
+	// All reads from the pipes must be completed before Wait call
+	<-stderrDone
+
+	if err := proc.Wait(); err != nil {
+		err := newCommandError(params.ctx, cmdName, err, stderr.String())
+
 		if params.logger != nil {
 			args := params.LogArgs
 			args = append(args,
@@ -79,7 +98,7 @@ func launchCommand(params launchParams) (*exec.Cmd, error) {
 			params.logger.LogAttrs(logCtx, slog.LevelError, cmdName+" run failed", args...)
 		}
 
-		return cmd, err
+		return "", err
 	}
 
 	if params.logger != nil {
@@ -88,5 +107,5 @@ func launchCommand(params launchParams) (*exec.Cmd, error) {
 		params.logger.LogAttrs(logCtx, slog.LevelInfo, cmdName+" command finished", args...)
 	}
 
-	return cmd, nil
+	return stdout.String(), nil
 }
@@ -15,6 +15,17 @@ const (
 	ValidationErrTypeScale
 	ValidationErrTypeSpiteDims
 	ValidationErrTypeScaleBehavior
+	ValidationErrTypeVTT
+	ValidationErrTypeFilter
+	ValidationErrTypeCount
+	ValidationErrTypeIntervalRules
+	ValidationErrTypeScene
+	ValidationErrTypeKeyframes
+	ValidationErrTypeStrategy
+	ValidationErrTypeSegments
+	ValidationErrTypeTimeRange
+	ValidationErrTypeRetryPolicy
+	ValidationErrTypeQueueSize
 )
 
 type ValidationError struct {
@@ -26,6 +37,127 @@ func (e *ValidationError) Error() string {
 	return e.Msg
 }
 
+// validateConfig checks generator-wide settings against the outputs
+func validateConfig(cfg *Config) error {
+	if err := validateStrategy(cfg.Strategy, cfg.Outputs); err != nil {
+		return err
+	}
+
+	if err := validateSegments(cfg.Segments, cfg.Outputs); err != nil {
+		return err
+	}
+
+	if err := validateRetryPolicy(cfg.RetryPolicy); err != nil {
+		return err
+	}
+
+	if cfg.QueueSize < 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeQueueSize,
+			Msg:  fmt.Sprintf("queue size cannot be negative, got %d", cfg.QueueSize),
+		}
+	}
+
+	if !cfg.KeyframesOnly {
+		return nil
+	}
+
+	for idx, output := range cfg.Outputs {
+		if !output.KeyframeSnapping {
+			return &ValidationError{
+				Type: ValidationErrTypeKeyframes,
+				Msg:  fmt.Sprintf("keyframes only mode is enabled, but output %d doesn't tolerate keyframe snapping", idx),
+			}
+		}
+
+		if output.Scene != nil {
+			return &ValidationError{
+				Type: ValidationErrTypeKeyframes,
+				Msg:  fmt.Sprintf("keyframes only mode cannot be used with output %d in scene mode", idx),
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateStrategy checks that the outputs could be generated with the provided strategy
+func validateStrategy(strategy GenerateStrategy, outputs []*OutputConfig) error {
+	switch strategy {
+	case GenerateStrategyAuto, GenerateStrategyDecodeAll:
+		return nil
+	case GenerateStrategySeek:
+		for idx, output := range outputs {
+			if output.Scene != nil {
+				return &ValidationError{
+					Type: ValidationErrTypeStrategy,
+					Msg:  fmt.Sprintf("seek strategy cannot be used with output %d in scene mode", idx),
+				}
+			}
+		}
+
+		return nil
+	default:
+		return &ValidationError{
+			Type: ValidationErrTypeStrategy,
+			Msg:  fmt.Sprintf("unknown generate strategy: %d", strategy),
+		}
+	}
+}
+
+// validateSegments checks that the outputs could be processed by time segments
+func validateSegments(segments int, outputs []*OutputConfig) error {
+	if segments < 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeSegments,
+			Msg:  fmt.Sprintf("segments number cannot be negative, got %d", segments),
+		}
+	}
+
+	if segments <= 1 {
+		return nil
+	}
+
+	for idx, output := range outputs {
+		if output.Scene != nil {
+			return &ValidationError{
+				Type: ValidationErrTypeSegments,
+				Msg:  fmt.Sprintf("segmented processing cannot be used with output %d in scene mode", idx),
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateRequestRanges checks request processing window and skip ranges
+func validateRequestRanges(req *GenerateRequest) error {
+	if req.Start < 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeTimeRange,
+			Msg:  fmt.Sprintf("start cannot be negative, got %s", req.Start),
+		}
+	}
+
+	if req.End != 0 && req.End <= req.Start {
+		return &ValidationError{
+			Type: ValidationErrTypeTimeRange,
+			Msg:  fmt.Sprintf("end must be after start, got start %s, end %s", req.Start, req.End),
+		}
+	}
+
+	for idx, r := range req.Skip {
+		if r.Start < 0 || r.End <= r.Start {
+			return &ValidationError{
+				Type: ValidationErrTypeTimeRange,
+				Msg:  fmt.Sprintf("skip range %d is invalid, got start %s, end %s", idx, r.Start, r.End),
+			}
+		}
+	}
+
+	return nil
+}
+
 func validateOutputs(outputs []*OutputConfig) error {
 	if len(outputs) == 0 {
 		return &ValidationError{
@@ -42,10 +174,30 @@ func validateOutputs(outputs []*OutputConfig) error {
 			}
 		}
 
-		if output.SnapshotInterval < time.Millisecond {
+		if output.Count < 0 {
 			return &ValidationError{
-				Type: ValidationErrTypeSnapshotInterval,
-				Msg:  fmt.Sprintf("output %d snapshot interval is less than one millesecond", idx),
+				Type: ValidationErrTypeCount,
+				Msg:  fmt.Sprintf("output %d has negative count: %d", idx, output.Count),
+			}
+		}
+
+		if output.IntervalRules != nil {
+			if err := validateIntervalRules(idx, output); err != nil {
+				return err
+			}
+		}
+
+		if output.Scene != nil {
+			if err := validateScene(idx, output); err != nil {
+				return err
+			}
+		}
+
+		// Interval of count mode and interval rules outputs is resolved per request,
+		// scene mode outputs don't need interval
+		if output.Count == 0 && output.IntervalRules == nil && output.Scene == nil {
+			if err := validateOutputSnapshotInterval(idx, output); err != nil {
+				return err
 			}
 		}
 
@@ -92,6 +244,21 @@ func validateOutputs(outputs []*OutputConfig) error {
 			}
 		}
 
+		if err := validateCustomFilters(idx, output.PreFilters); err != nil {
+			return err
+		}
+
+		if err := validateCustomFilters(idx, output.PostFilters); err != nil {
+			return err
+		}
+
+		if output.VTT != nil && output.Type != OutputTypeSprites {
+			return &ValidationError{
+				Type: ValidationErrTypeVTT,
+				Msg:  fmt.Sprintf("output %d has VTT track enabled, but it is supported only for sprites", idx),
+			}
+		}
+
 		switch output.Scale.Behavior {
 		case ScaleBehaviorNone, ScaleBehaviorFillToKeepAspectRatio, ScaleBehaviorCropToFit:
 		default:
@@ -104,3 +271,148 @@ func validateOutputs(outputs []*OutputConfig) error {
 
 	return nil
 }
+
+func validateIntervalRules(idx int, output *OutputConfig) error {
+	if output.Count > 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeIntervalRules,
+			Msg:  fmt.Sprintf("output %d cannot use both count mode and interval rules", idx),
+		}
+	}
+
+	if len(output.IntervalRules.Rules) == 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeIntervalRules,
+			Msg:  fmt.Sprintf("output %d interval rules are empty", idx),
+		}
+	}
+
+	if output.IntervalRules.MaxFrames < 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeIntervalRules,
+			Msg:  fmt.Sprintf("output %d interval rules has negative max frames: %d", idx, output.IntervalRules.MaxFrames),
+		}
+	}
+
+	for ruleIdx, rule := range output.IntervalRules.Rules {
+		if rule.Interval < time.Millisecond {
+			return &ValidationError{
+				Type: ValidationErrTypeIntervalRules,
+				Msg:  fmt.Sprintf("output %d interval rule %d interval is less than one millesecond", idx, ruleIdx),
+			}
+		}
+
+		if rule.MaxDuration < 0 {
+			return &ValidationError{
+				Type: ValidationErrTypeIntervalRules,
+				Msg:  fmt.Sprintf("output %d interval rule %d has negative max duration", idx, ruleIdx),
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateScene(idx int, output *OutputConfig) error {
+	if output.Count > 0 || output.IntervalRules != nil {
+		return &ValidationError{
+			Type: ValidationErrTypeScene,
+			Msg:  fmt.Sprintf("output %d scene mode cannot be used with count mode or interval rules", idx),
+		}
+	}
+
+	if output.ExactAlignment {
+		return &ValidationError{
+			Type: ValidationErrTypeScene,
+			Msg:  fmt.Sprintf("output %d scene mode cannot be used with exact alignment", idx),
+		}
+	}
+
+	scene := output.Scene
+
+	if scene.Threshold < 0 || scene.Threshold > 1 {
+		return &ValidationError{
+			Type: ValidationErrTypeScene,
+			Msg:  fmt.Sprintf("output %d has wrong scene threshold, valid values are 0-1, got %g", idx, scene.Threshold),
+		}
+	}
+
+	if scene.MinGap < 0 || scene.MaxGap < 0 {
+		return &ValidationError{
+			Type: ValidationErrTypeScene,
+			Msg:  fmt.Sprintf("output %d scene gaps cannot be negative", idx),
+		}
+	}
+
+	if scene.MaxGap > 0 && scene.MaxGap < scene.MinGap {
+		return &ValidationError{
+			Type: ValidationErrTypeScene,
+			Msg:  fmt.Sprintf("output %d scene max gap is less than min gap", idx),
+		}
+	}
+
+	return nil
+}
+
+func validateOutputSnapshotInterval(idx int, output *OutputConfig) error {
+	if output.SnapshotInterval < time.Millisecond {
+		return &ValidationError{
+			Type: ValidationErrTypeSnapshotInterval,
+			Msg:  fmt.Sprintf("output %d snapshot interval is less than one millesecond", idx),
+		}
+	}
+
+	return nil
+}
+
+// validateResolvedOutputs checks outputs which are ready to be passed into the filter graph,
+// i.e. snapshot intervals of all outputs are resolved
+func validateResolvedOutputs(outputs []*OutputConfig) error {
+	if err := validateOutputs(outputs); err != nil {
+		return err
+	}
+
+	for idx, output := range outputs {
+		if output.Scene != nil {
+			continue
+		}
+
+		if err := validateOutputSnapshotInterval(idx, output); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateCustomFilters(outputIdx int, filters []*Filter) error {
+	for _, filter := range filters {
+		if filter == nil || len(filter.Name) == 0 {
+			return &ValidationError{
+				Type: ValidationErrTypeFilter,
+				Msg:  fmt.Sprintf("output %d has custom filter without name", outputIdx),
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateOutputsFiltersAvailability checks that custom filters of the outputs are supported by ffmpeg build,
+// availableFilters is a set of filter names supported by ffmpeg (see GetFfmpegFilters)
+func validateOutputsFiltersAvailability(outputs []*OutputConfig, availableFilters map[string]struct{}) error {
+	for idx, output := range outputs {
+		for _, filters := range [][]*Filter{output.PreFilters, output.PostFilters} {
+			for _, filter := range filters {
+				if _, ok := availableFilters[filter.Name]; !ok {
+					return &ValidationError{
+						Type: ValidationErrTypeFilter,
+						Msg:  fmt.Sprintf("output %d has custom filter %q which is not supported by ffmpeg", idx, filter.Name),
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
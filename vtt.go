@@ -0,0 +1,222 @@
+package ffthumbs
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VTTCue is a single WebVTT thumbnails track cue
+type VTTCue struct {
+	Start time.Duration
+	End   time.Duration
+	// URL is a cue payload, e.g. "sprites/0001.jpg#xywh=0,0,320,180"
+	URL string
+}
+
+// spriteVTTParams describes how to build WebVTT cues for the sprites output
+type spriteVTTParams struct {
+	// BaseURL is prepended to the sprite reference, when both BaseURL and URLTemplate are empty
+	// sprite path relative to VTTDir is used
+	BaseURL string
+	// URLTemplate is a sprite reference template (see VTTConfig.URLTemplate), default: sprite filename
+	URLTemplate string
+	// TilesPerSprite is a number of tiles in the sprite, it is used to resolve the sprite number
+	TilesPerSprite int
+	// VTTDir is a directory where WebVTT track will be placed
+	VTTDir string
+
+	// Interval is a snapshot interval, when zero cues are built from the frames timestamps
+	Interval time.Duration
+	// Aligned tells that frames are selected per interval range (see OutputConfig.ExactAlignment),
+	// each cue covers the interval range of the frame timestamp
+	Aligned bool
+	// Start is the timeline start of the interval ranges
+	Start time.Duration
+	// Duration is a media duration, the last cue never ends after it
+	Duration time.Duration
+}
+
+// buildSpriteVTTCues builds WebVTT cues for a sprites output, each cue maps
+// one snapshot interval (or time range until the next frame) to the tile of the sprite where the frame was placed in
+func buildSpriteVTTCues(params *spriteVTTParams, frames []FrameInfo) []VTTCue {
+	cues := make([]VTTCue, 0, len(frames))
+
+	for i, frame := range frames {
+		if frame.Tile == nil {
+			continue
+		}
+
+		var start, end time.Duration
+
+		if params.Aligned && params.Interval > 0 {
+			start = alignedRangeStart(params, frame.PTS)
+			if i == 0 {
+				start = params.Start
+			}
+
+			end = start + params.Interval
+			if i+1 < len(frames) {
+				// Ranges without frames are covered by the previous cue
+				end = alignedRangeStart(params, frames[i+1].PTS)
+			}
+		} else if params.Interval > 0 {
+			start = time.Duration(frame.Index) * params.Interval
+			end = start + params.Interval
+		} else {
+			start = frame.PTS
+			if i+1 < len(frames) {
+				end = frames[i+1].PTS
+			} else {
+				end = max(params.Duration, start+time.Millisecond)
+			}
+		}
+
+		if params.Duration > 0 && end > params.Duration {
+			end = params.Duration
+		}
+
+		cues = append(cues, VTTCue{
+			Start: start,
+			End:   end,
+			URL: fmt.Sprintf("%s#xywh=%d,%d,%d,%d",
+				buildVTTSpriteURL(params, frame),
+				frame.Tile.X, frame.Tile.Y, frame.Tile.Width, frame.Tile.Height,
+			),
+		})
+	}
+
+	return cues
+}
+
+// alignedRangeStart returns start of the interval range containing pts
+func alignedRangeStart(params *spriteVTTParams, pts time.Duration) time.Duration {
+	if pts <= params.Start {
+		return params.Start
+	}
+
+	return params.Start + (pts-params.Start)/params.Interval*params.Interval
+}
+
+// buildVTTSpriteURL returns the cue reference to the sprite containing the frame
+func buildVTTSpriteURL(params *spriteVTTParams, frame FrameInfo) string {
+	if len(params.BaseURL) == 0 && len(params.URLTemplate) == 0 {
+		if relPath, err := filepath.Rel(params.VTTDir, frame.File); err == nil {
+			return filepath.ToSlash(relPath)
+		}
+
+		return filepath.ToSlash(frame.File)
+	}
+
+	// Sprite path is a filesystem path, the reference is a URL path
+	name := path.Base(filepath.ToSlash(frame.File))
+
+	if len(params.URLTemplate) > 0 {
+		spriteNum := 1
+		if params.TilesPerSprite > 0 {
+			spriteNum = frame.Index/params.TilesPerSprite + 1
+		}
+
+		name = strings.NewReplacer(
+			"{name}", name,
+			"{index}", strconv.Itoa(spriteNum),
+		).Replace(params.URLTemplate)
+	}
+
+	return params.BaseURL + name
+}
+
+// WriteVTT writes WebVTT track containing provided cues
+func WriteVTT(w io.Writer, cues []VTTCue) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString("WEBVTT\n")
+
+	for _, cue := range cues {
+		bw.WriteString("\n")
+		bw.WriteString(formatVTTTime(cue.Start))
+		bw.WriteString(" --> ")
+		bw.WriteString(formatVTTTime(cue.End))
+		bw.WriteString("\n")
+		bw.WriteString(cue.URL)
+		bw.WriteString("\n")
+	}
+
+	return bw.Flush()
+}
+
+// writeVTTFile writes WebVTT track into the file located at dst
+func writeVTTFile(dst string, cues []VTTCue) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("cannot create vtt file: %w", err)
+	}
+
+	if err := WriteVTT(f, cues); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write vtt file: %w", err)
+	}
+
+	return f.Close()
+}
+
+// formatVTTTime formats duration as WebVTT timestamp, e.g. 01:02:03.456
+func formatVTTTime(d time.Duration) string {
+	d = d.Round(time.Millisecond)
+
+	hours := d / time.Hour
+	d -= hours * time.Hour
+
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+
+	seconds := d / time.Second
+	d -= seconds * time.Second
+
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, seconds, d/time.Millisecond)
+}
+
+// formatOutputFilename resolves image sequence pattern (e.g. "%04d.jpg") into the concrete filename
+func formatOutputFilename(dst string, num int) string {
+	if !strings.Contains(dst, "%") {
+		return dst
+	}
+
+	return fmt.Sprintf(dst, num)
+}
+
+// resolveTileSize returns sprite tile resolution, when output scale isn't fixed the resolution
+// is detected from the first generated sprite
+func resolveTileSize(output *OutputConfig, spriteDst string) (width, height int, err error) {
+	if output.Scale.IsFixedResolution() {
+		return output.Scale.Width, output.Scale.Height, nil
+	}
+
+	f, err := os.Open(formatOutputFilename(spriteDst, 1))
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot open sprite to detect tile size: %w", err)
+	}
+	defer f.Close()
+
+	imgCfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot decode sprite to detect tile size: %w", err)
+	}
+
+	return imgCfg.Width / output.Sprites.Dimensions.Columns,
+		imgCfg.Height / output.Sprites.Dimensions.Rows,
+		nil
+}
+
+func durationFromSeconds(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
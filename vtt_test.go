@@ -0,0 +1,144 @@
+package ffthumbs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// spriteFrames returns frames of the 2x2 sprites output with 160x90 tiles, frames are taken at pts
+func spriteFrames(t *testing.T, pts ...time.Duration) []FrameInfo {
+	t.Helper()
+
+	output := &OutputConfig{
+		Type:    OutputTypeSprites,
+		Scale:   ScaleConfig{Width: 160, Height: 90},
+		Sprites: SpritesConfig{Dimensions: SpriteDimensions{Columns: 2, Rows: 2}},
+	}
+
+	frames := make([]capturedFrame, 0, len(pts))
+	for n, frame := range pts {
+		frames = append(frames, capturedFrame{n: n, pts: frame})
+	}
+
+	res, err := buildOutputResult(output, "media/sprites/%04d.jpg", frames, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return res.Frames
+}
+
+func TestBuildSpriteVTTCues(t *testing.T) {
+	tests := []struct {
+		name   string
+		params spriteVTTParams
+		pts    []time.Duration
+		want   []VTTCue
+	}{
+		{
+			name:   "interval with last partial sprite",
+			params: spriteVTTParams{VTTDir: "media", Interval: 10 * time.Second, Duration: 45 * time.Second},
+			pts:    []time.Duration{0, 10 * time.Second, 20 * time.Second, 30 * time.Second, 40 * time.Second},
+			want: []VTTCue{
+				{Start: 0, End: 10 * time.Second, URL: "sprites/0001.jpg#xywh=0,0,160,90"},
+				{Start: 10 * time.Second, End: 20 * time.Second, URL: "sprites/0001.jpg#xywh=160,0,160,90"},
+				{Start: 20 * time.Second, End: 30 * time.Second, URL: "sprites/0001.jpg#xywh=0,90,160,90"},
+				{Start: 30 * time.Second, End: 40 * time.Second, URL: "sprites/0001.jpg#xywh=160,90,160,90"},
+				{Start: 40 * time.Second, End: 45 * time.Second, URL: "sprites/0002.jpg#xywh=0,0,160,90"},
+			},
+		},
+		{
+			name:   "timestamps",
+			params: spriteVTTParams{VTTDir: "media/sprites", Duration: 20 * time.Second},
+			pts:    []time.Duration{0, 3 * time.Second, 11500 * time.Millisecond},
+			want: []VTTCue{
+				{Start: 0, End: 3 * time.Second, URL: "0001.jpg#xywh=0,0,160,90"},
+				{Start: 3 * time.Second, End: 11500 * time.Millisecond, URL: "0001.jpg#xywh=160,0,160,90"},
+				{Start: 11500 * time.Millisecond, End: 20 * time.Second, URL: "0001.jpg#xywh=0,90,160,90"},
+			},
+		},
+		{
+			name: "aligned with gap",
+			params: spriteVTTParams{
+				VTTDir:   "media",
+				Interval: 10 * time.Second,
+				Aligned:  true,
+				Start:    5 * time.Second,
+				Duration: 40 * time.Second,
+			},
+			pts: []time.Duration{7 * time.Second, 16 * time.Second, 36 * time.Second},
+			want: []VTTCue{
+				{Start: 5 * time.Second, End: 15 * time.Second, URL: "sprites/0001.jpg#xywh=0,0,160,90"},
+				{Start: 15 * time.Second, End: 35 * time.Second, URL: "sprites/0001.jpg#xywh=160,0,160,90"},
+				{Start: 35 * time.Second, End: 40 * time.Second, URL: "sprites/0001.jpg#xywh=0,90,160,90"},
+			},
+		},
+		{
+			name:   "base url",
+			params: spriteVTTParams{BaseURL: "https://cdn.example.com/1/", Interval: 10 * time.Second, Duration: 60 * time.Second},
+			pts:    []time.Duration{0, 10 * time.Second, 20 * time.Second, 30 * time.Second, 40 * time.Second},
+			want: []VTTCue{
+				{Start: 0, End: 10 * time.Second, URL: "https://cdn.example.com/1/0001.jpg#xywh=0,0,160,90"},
+				{Start: 10 * time.Second, End: 20 * time.Second, URL: "https://cdn.example.com/1/0001.jpg#xywh=160,0,160,90"},
+				{Start: 20 * time.Second, End: 30 * time.Second, URL: "https://cdn.example.com/1/0001.jpg#xywh=0,90,160,90"},
+				{Start: 30 * time.Second, End: 40 * time.Second, URL: "https://cdn.example.com/1/0001.jpg#xywh=160,90,160,90"},
+				{Start: 40 * time.Second, End: 50 * time.Second, URL: "https://cdn.example.com/1/0002.jpg#xywh=0,0,160,90"},
+			},
+		},
+		{
+			name: "url template",
+			params: spriteVTTParams{
+				BaseURL:        "/thumbs/",
+				URLTemplate:    "sprite-{index}.jpg?src={name}",
+				TilesPerSprite: 4,
+				Interval:       10 * time.Second,
+				Duration:       45 * time.Second,
+			},
+			pts: []time.Duration{0, 10 * time.Second, 20 * time.Second, 30 * time.Second, 40 * time.Second},
+			want: []VTTCue{
+				{Start: 0, End: 10 * time.Second, URL: "/thumbs/sprite-1.jpg?src=0001.jpg#xywh=0,0,160,90"},
+				{Start: 10 * time.Second, End: 20 * time.Second, URL: "/thumbs/sprite-1.jpg?src=0001.jpg#xywh=160,0,160,90"},
+				{Start: 20 * time.Second, End: 30 * time.Second, URL: "/thumbs/sprite-1.jpg?src=0001.jpg#xywh=0,90,160,90"},
+				{Start: 30 * time.Second, End: 40 * time.Second, URL: "/thumbs/sprite-1.jpg?src=0001.jpg#xywh=160,90,160,90"},
+				{Start: 40 * time.Second, End: 45 * time.Second, URL: "/thumbs/sprite-2.jpg?src=0002.jpg#xywh=0,0,160,90"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cues := buildSpriteVTTCues(&tt.params, spriteFrames(t, tt.pts...))
+
+			if len(cues) != len(tt.want) {
+				t.Fatalf("expected %d cues, got %d: %+v", len(tt.want), len(cues), cues)
+			}
+
+			for i, cue := range cues {
+				if cue != tt.want[i] {
+					t.Errorf("cue %d:\ngot:  %+v\nwant: %+v", i, cue, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var sb strings.Builder
+
+	err := WriteVTT(&sb, []VTTCue{
+		{Start: 0, End: 10 * time.Second, URL: "0001.jpg#xywh=0,0,160,90"},
+		{Start: time.Hour + 2*time.Minute + 3456*time.Millisecond, End: time.Hour + 2*time.Minute + 4*time.Second, URL: "0002.jpg#xywh=160,0,160,90"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:10.000\n0001.jpg#xywh=0,0,160,90\n" +
+		"\n01:02:03.456 --> 01:02:04.000\n0002.jpg#xywh=160,0,160,90\n"
+
+	if sb.String() != want {
+		t.Errorf("unexpected track:\n%s", sb.String())
+	}
+}